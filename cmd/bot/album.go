@@ -0,0 +1,196 @@
+package main
+
+import (
+	"secretsquirrel/database"
+	"secretsquirrel/messages"
+	"sort"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// AlbumWaitTime is how long to wait after the last item of a media group before relaying it.
+const AlbumWaitTime = 1500 * time.Millisecond
+
+type pendingAlbum struct {
+	items []*BotContext
+	timer *time.Timer
+}
+
+// AlbumBuffer collects the updates of a media group so they can be relayed as a single unit.
+type AlbumBuffer struct {
+	mu     sync.Mutex
+	albums map[string]*pendingAlbum
+	done   func([]*BotContext)
+}
+
+func NewAlbumBuffer(done func([]*BotContext)) *AlbumBuffer {
+	return &AlbumBuffer{
+		mu:     sync.Mutex{},
+		albums: make(map[string]*pendingAlbum),
+		done:   done,
+	}
+}
+
+// add buffers an album item. The album is flushed once no new items have arrived for AlbumWaitTime.
+func (ab *AlbumBuffer) add(ctx *BotContext) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	id := ctx.Message.MediaGroupID
+
+	if album, ok := ab.albums[id]; ok {
+		album.items = append(album.items, ctx)
+		album.timer.Reset(AlbumWaitTime)
+		return
+	}
+
+	ab.albums[id] = &pendingAlbum{
+		items: []*BotContext{ctx},
+		timer: time.AfterFunc(AlbumWaitTime, func() { ab.flush(id) }),
+	}
+}
+
+func (ab *AlbumBuffer) flush(id string) {
+	ab.mu.Lock()
+	album, ok := ab.albums[id]
+	delete(ab.albums, id)
+	ab.mu.Unlock()
+
+	if ok {
+		sort.Slice(album.items, func(i, j int) bool {
+			return album.items[i].Message.MessageID < album.items[j].Message.MessageID
+		})
+		ab.done(album.items)
+	}
+}
+
+// flushAll relays every pending album right away.
+func (ab *AlbumBuffer) flushAll() {
+	ab.mu.Lock()
+	var ids []string
+	for id, album := range ab.albums {
+		album.timer.Stop()
+		ids = append(ids, id)
+	}
+	ab.mu.Unlock()
+
+	for _, id := range ids {
+		ab.flush(id)
+	}
+}
+
+// handleAlbum is the media group equivalent of handleMessage.
+func (bot *SecretSquirrel) handleAlbum(album []*BotContext) {
+	bot.Queue.mu.Lock()
+	defer bot.Queue.mu.Unlock()
+
+	// the bot is shutting down.
+	if bot.Queue.closed {
+		return
+	}
+
+	first := album[0]
+
+	// ignore albums from untracked users and users who left.
+	if first.User == nil || first.User.Left.Valid {
+		bot.sendSystemMessage(first.Message.From.ID, messages.UserNotInChatMessage)
+		return
+	}
+
+	// check media limit period
+	if cfg.Limits.MediaLimitPeriod > 0 {
+		if int(time.Since(first.User.Joined).Hours()) < cfg.Limits.MediaLimitPeriod {
+			bot.sendSystemMessage(first.User.ID, messages.MediaLimitError)
+			return
+		}
+	}
+
+	// the whole album only counts once towards the spam score.
+	if ok := bot.Spam.increaseSpamScore(first.User.ID, calculateSpamScore(first)); !ok {
+		bot.sendSystemMessage(first.User.ID, messages.SpamError)
+		return
+	}
+
+	for _, ctx := range album {
+		if ctx.ContentType == DocumentContentType && !cfg.Limits.AllowDocuments {
+			return
+		}
+	}
+
+	// only decorate the first caption with the tripcode.
+	if first.User.ToggleTripcode {
+		first.Tripcode = true
+	}
+
+	bot.markActive(first.User)
+
+	for _, ctx := range album {
+		ctx.CacheMessageID = bot.Cache.newMessage(ctx)
+	}
+
+	var jobs []*QueueJob
+	for _, user := range bot.Users.snapshot(bot.UserQueue.Get()) {
+		user := user
+		if user.ID == first.Message.From.ID && !user.DebugEnabled {
+			for _, ctx := range album {
+				bot.Cache.saveMapping(user.ID, ctx.CacheMessageID, ctx.Message.MessageID)
+			}
+			continue
+		}
+
+		jobs = append(jobs, &QueueJob{Bot: bot, User: &user, Context: first, Album: album})
+	}
+	bot.Queue.push(jobs)
+	for _, ctx := range album {
+		relayedMessages.WithLabelValues(ctx.ContentType.String()).Inc()
+	}
+}
+
+// NewMediaGroup creates the media group config for a user's copy of an album.
+func (bot *SecretSquirrel) NewMediaGroup(album []*BotContext, user *database.User) tgbotapi.MediaGroupConfig {
+	var (
+		files []interface{}
+		first = album[0]
+	)
+
+	for _, ctx := range album {
+		base := tgbotapi.BaseInputMedia{
+			Caption:   formatMessageText(ctx),
+			ParseMode: "HTML",
+		}
+
+		switch ctx.ContentType {
+		case PhotoContentType:
+			base.Type = "photo"
+			base.Media = tgbotapi.FileID(ctx.Message.Photo[len(ctx.Message.Photo)-1].FileID)
+			files = append(files, tgbotapi.InputMediaPhoto{BaseInputMedia: base})
+		case VideoContentType:
+			base.Type = "video"
+			base.Media = tgbotapi.FileID(ctx.Message.Video.FileID)
+			files = append(files, tgbotapi.InputMediaVideo{BaseInputMedia: base})
+		case AudioContentType:
+			base.Type = "audio"
+			base.Media = tgbotapi.FileID(ctx.Message.Audio.FileID)
+			files = append(files, tgbotapi.InputMediaAudio{BaseInputMedia: base})
+		case DocumentContentType:
+			base.Type = "document"
+			base.Media = tgbotapi.FileID(ctx.Message.Document.FileID)
+			files = append(files, tgbotapi.InputMediaDocument{BaseInputMedia: base})
+		}
+	}
+
+	config := tgbotapi.NewMediaGroup(user.ID, files)
+
+	if first.ReplyID != -1 {
+		replyID, err := bot.Cache.lookupCacheMessageValue(user.ID, first.ReplyID)
+		if err != nil {
+			bot.logUser(user.ID).WithError(err).Debug("reply is not in the message cache.")
+		} else {
+			config.ReplyToMessageID = replyID
+		}
+	}
+
+	return config
+}
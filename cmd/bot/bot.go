@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"net/http"
 	"secretsquirrel/config"
 	"secretsquirrel/crypt"
 	"secretsquirrel/database"
+	"secretsquirrel/logging"
 	"secretsquirrel/messages"
+	"secretsquirrel/telegram"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-co-op/gocron"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
@@ -22,59 +26,76 @@ const (
 )
 
 type SecretSquirrel struct {
-	Api       *tgbotapi.BotAPI
+	Api       telegram.API
 	Db        *gorm.DB
 	Users     *UserCache
 	Cache     *MessageCache
 	UserQueue *PriorityQueue
 	Queue     *Queue
+	Limiter   *RateLimiter
 	Spam      *Scorekeeper
+	Albums    *AlbumBuffer
 	Scheduler *gocron.Scheduler
-}
+	Log       *logrus.Logger
 
-type Queue struct {
-	ch chan *QueueJob
-	mu sync.Mutex
+	webhook *http.Server
+	metrics *http.Server
 }
 
 // handleMessage does further checks on the message and user before queueing the job for relaying by workers.
-func (bot *SecretSquirrel) handleMessage(ctx *BotContext) error {
+// It reports whether the message was queued.
+func (bot *SecretSquirrel) handleMessage(ctx *BotContext) (bool, error) {
 	bot.Queue.mu.Lock()
 	defer bot.Queue.mu.Unlock()
 
+	// the bot is shutting down.
+	if bot.Queue.closed {
+		return false, nil
+	}
+
 	// ignore messages from untracked users.
-	if ctx.User == nil {
-		bot.sendSystemMessage(ctx.User.ID, messages.UserNotInChatMessage)
-		return nil
+	if ctx.User == nil || ctx.User.Left.Valid {
+		bot.sendSystemMessage(ctx.Message.From.ID, messages.UserNotInChatMessage)
+		return false, nil
 	}
 
 	// check media limit period
 	if (ctx.HasFile() || ctx.IsForward()) && cfg.Limits.MediaLimitPeriod > 0 {
 		if int(time.Since(ctx.User.Joined).Hours()) < cfg.Limits.MediaLimitPeriod {
 			bot.sendSystemMessage(ctx.User.ID, messages.MediaLimitError)
-			return nil
+			return false, nil
+		}
+	}
+
+	// check if user is spamming. official staff messages are exempt.
+	if !ctx.IsOfficial() {
+		if ok := bot.Spam.increaseSpamScore(ctx.User.ID, calculateSpamScore(ctx)); !ok {
+			bot.sendSystemMessage(ctx.User.ID, messages.SpamError)
+			return false, nil
 		}
 	}
 
-	// check if user is spamming.
-	if ok := bot.Spam.increaseSpamScore(ctx.User.ID, calculateSpamScore(ctx)); !ok {
-		bot.sendSystemMessage(ctx.User.ID, messages.SpamError)
-		return nil
+	// official messages must not reveal who sent them.
+	if ctx.User.ToggleTripcode && !ctx.IsOfficial() {
+		ctx.Tripcode = true
 	}
 
+	bot.markActive(ctx.User)
+
 	ctx.CacheMessageID = bot.Cache.newMessage(ctx)
 
 	// check types limits
 	if ctx.ContentType == DocumentContentType && !cfg.Limits.AllowDocuments {
-		return nil
+		return false, nil
 	}
 	if ctx.ContentType == ContactContentType && !cfg.Limits.AllowContacts {
-		return nil
+		return false, nil
 	}
 
 	// echo message to all users.
-	for _, uindex := range bot.UserQueue.Get() {
-		user := (*bot.Users)[uindex]
+	var jobs []*QueueJob
+	for _, user := range bot.Users.snapshot(bot.UserQueue.Get()) {
+		user := user
 		// only resend message back to the sender if debug is enabled.
 		// this seems to break replies while debug is enabled. idk why
 		if user.ID == ctx.Message.From.ID && !user.DebugEnabled {
@@ -82,10 +103,12 @@ func (bot *SecretSquirrel) handleMessage(ctx *BotContext) error {
 			continue
 		}
 
-		bot.Queue.ch <- &QueueJob{Bot: bot, User: &user, Context: ctx}
+		jobs = append(jobs, &QueueJob{Bot: bot, User: &user, Context: ctx})
 	}
+	bot.Queue.push(jobs)
+	relayedMessages.WithLabelValues(ctx.ContentType.String()).Inc()
 
-	return nil
+	return true, nil
 }
 
 // handleUpdate is the first step of message processing. It handles the initial tgbotapi.Update from the API.
@@ -97,7 +120,15 @@ func (bot *SecretSquirrel) handleUpdate(u tgbotapi.Update) {
 
 	// if user left/blocked the bot.
 	if ctx.UserLeftOrKicked() {
-		bot.UpdateUser(ctx.User, "left", sql.NullTime{Time: time.Now(), Valid: true})
+		if ctx.User != nil {
+			bot.leaveUser(ctx.User)
+		}
+		return
+	}
+
+	// inline keyboard button presses.
+	if ctx.Callback != nil {
+		bot.handleCallback(ctx)
 		return
 	}
 
@@ -124,6 +155,11 @@ func (bot *SecretSquirrel) handleUpdate(u tgbotapi.Update) {
 		}
 	}
 
+	if ctx.IsEdit() {
+		bot.handleEdit(ctx)
+		return
+	}
+
 	// If the message is a command, check that it's in the map of valid commands.
 	// run the command if it is.
 	if cmd, ok := BotCommands[ctx.Message.Command()]; ok {
@@ -132,14 +168,79 @@ func (bot *SecretSquirrel) handleUpdate(u tgbotapi.Update) {
 		return
 	}
 
+	// albums are buffered and relayed together once all of their items arrived.
+	if ctx.Message.MediaGroupID != "" {
+		bot.Albums.add(ctx)
+		return
+	}
+
 	if ctx.IsReply() && strings.TrimSpace(ctx.Message.Text) == "+1" {
 		bot.giveKarma(ctx)
 		return
 	}
 
-	if err := bot.handleMessage(ctx); err != nil {
-		fmt.Println(err)
+	if _, err := bot.handleMessage(ctx); err != nil {
+		bot.logUser(ctx.Message.From.ID).WithError(err).Error("failed to handle message.")
+	}
+}
+
+// handleEdit queues an edited message for every user that received a copy of it.
+func (bot *SecretSquirrel) handleEdit(ctx *BotContext) {
+	bot.Queue.mu.Lock()
+	defer bot.Queue.mu.Unlock()
+
+	// the bot is shutting down.
+	if bot.Queue.closed {
+		return
+	}
+
+	if ctx.User == nil || ctx.User.Left.Valid {
+		return
+	}
+
+	msid, err := bot.Cache.lookupCacheMessageKey(ctx.User.ID, ctx.Message.MessageID)
+	if err != nil {
+		return
+	}
+
+	cm, err := bot.Cache.getMessage(msid)
+	if err != nil {
+		return
+	}
+
+	// re-apply the decorations the original message was sent with.
+	ctx.CacheMessageID = msid
+	ctx.Signed = cm.signed
+	ctx.Tripcode = cm.tripcode
+	ctx.Official = cm.official
+
+	// only text and captions can be edited.
+	if bot.NewEdit(ctx, ctx.User, ctx.Message.MessageID) == nil {
+		return
+	}
+
+	// edits count towards the spam score like new messages.
+	if !ctx.IsOfficial() {
+		if ok := bot.Spam.increaseSpamScore(ctx.User.ID, calculateSpamScore(ctx)); !ok {
+			bot.sendSystemMessage(ctx.User.ID, messages.SpamError)
+			return
+		}
+	}
+
+	var jobs []*QueueJob
+	for _, user := range bot.Users.snapshot(bot.UserQueue.Get()) {
+		user := user
+		if user.ID == ctx.User.ID && !user.DebugEnabled {
+			continue
+		}
+
+		if _, err := bot.Cache.lookupCacheMessageValue(user.ID, msid); err != nil {
+			continue
+		}
+
+		jobs = append(jobs, &QueueJob{Bot: bot, User: &user, Context: ctx, Edit: true})
 	}
+	bot.Queue.push(jobs)
 }
 
 func (bot *SecretSquirrel) giveKarma(ctx *BotContext) {
@@ -164,14 +265,18 @@ func (bot *SecretSquirrel) giveKarma(ctx *BotContext) {
 		return
 	}
 
-	user := (*bot.Users)[cm.userID]
+	user, ok := bot.Users.get(cm.userID)
+	if !ok {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NotInCacheError, ctx.Message.MessageID)
+		return
+	}
 
 	bot.UpdatesUser(&user, database.User{Karma: user.Karma + cfg.Karma.KarmaPlusOne})
-	cm.addUpvote(ctx.User.ID)
+	bot.Cache.addUpvote(ctx.ReplyID, ctx.User.ID)
 
 	if !user.HideKarma {
 		reply, _ := bot.Cache.lookupCacheMessageValue(cm.userID, ctx.ReplyID)
-		bot.sendSystemMessageReply(user.ID, messages.UpvoteOwnMessageError, reply)
+		bot.sendSystemMessageReply(user.ID, messages.KarmaNotificationMessage, reply)
 	}
 
 	bot.sendSystemMessage(ctx.User.ID, messages.KarmaThankMessage)
@@ -189,12 +294,51 @@ func (bot *SecretSquirrel) AddWarning(cfg config.Config, user *database.User) ti
 
 	bot.UpdatesUser(user, database.User{
 		CooldownUntil: sql.NullTime{Time: time.Now().Add(time.Minute * time.Duration(cooldownTime)), Valid: true},
+		Warnings:      user.Warnings + 1,
+		WarnExpiry:    sql.NullTime{Time: time.Now().Add(time.Hour * time.Duration(cfg.Cooldown.WarnExpireHours)), Valid: true},
 		Karma:         user.Karma - cfg.Karma.KarmaWarnPenalty,
 	})
 
 	return user.CooldownUntil.Time
 }
 
+// expireWarnings removes one warning from every user who hasn't been warned in the last WarnExpireHours.
+func (bot *SecretSquirrel) expireWarnings() {
+	if cfg.Cooldown.WarnExpireHours <= 0 {
+		return
+	}
+
+	users, err := database.FindUsers(bot.Db, database.WarningExpired)
+	if err != nil {
+		bot.Log.WithError(err).Error("failed to find expired warnings.")
+		return
+	}
+
+	for _, user := range users {
+		warnings := user.Warnings - 1
+		warnExpiry := sql.NullTime{}
+		if warnings > 0 {
+			warnExpiry = sql.NullTime{Time: time.Now().Add(time.Hour * time.Duration(cfg.Cooldown.WarnExpireHours)), Valid: true}
+		}
+
+		err := bot.Db.Model(&database.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"warnings":    warnings,
+			"warn_expiry": warnExpiry,
+		}).Error
+		if err != nil {
+			bot.logUser(user.ID).WithError(err).Error("failed to expire warning.")
+			continue
+		}
+
+		// only the changed fields are applied, the cached user may be newer than the one loaded above.
+		// left users aren't tracked in the user cache.
+		bot.Users.update(user.ID, func(u *database.User) {
+			u.Warnings = warnings
+			u.WarnExpiry = warnExpiry
+		})
+	}
+}
+
 func (bot *SecretSquirrel) sendSystemMessage(userID int64, message string) (tgbotapi.Message, error) {
 	msg := tgbotapi.MessageConfig{
 		BaseChat:              tgbotapi.BaseChat{ChatID: userID},
@@ -222,48 +366,177 @@ func (bot *SecretSquirrel) sendSystemMessageReply(userID int64, message string,
 	return bot.Api.Send(&msg)
 }
 
+// markActive updates a user's LastActive and moves them to the front of the delivery queue.
+// Only LastActive is written to the cache, user may be an older copy than the cached one.
+func (bot *SecretSquirrel) markActive(user *database.User) {
+	now := time.Now()
+	if err := bot.Db.Model(user).Update("last_active", now).Error; err != nil {
+		bot.logUser(user.ID).WithError(err).Error("failed to update user.")
+	}
+	bot.Users.update(user.ID, func(u *database.User) {
+		u.LastActive = now
+	})
+	bot.UserQueue.Update(user.ID)
+}
+
+// leaveUser marks a user as having left the chat and stops relaying messages to them.
+func (bot *SecretSquirrel) leaveUser(user *database.User) {
+	bot.UpdateUser(user, "left", sql.NullTime{Time: time.Now(), Valid: true})
+	bot.UserQueue.Remove(user.ID)
+	bot.Users.delete(user.ID)
+	bot.Limiter.forget(user.ID)
+
+	bot.logUser(user.ID).Info("user left.")
+}
+
 // UpdateUser wraps *gorm.DB.Model().Update() and adds the updated database.User to the bot cache.
 func (bot *SecretSquirrel) UpdateUser(user *database.User, column string, value interface{}) {
 	bot.Db.Model(user).Update(column, value)
-	(*bot.Users)[user.ID] = *user
+	bot.cacheUser(user)
 }
 
 // UpdateUser wraps *gorm.DB.Model().Updates() and adds the updated database.User to the bot cache.
 func (bot *SecretSquirrel) UpdatesUser(user *database.User, values interface{}) {
 	bot.Db.Model(user).Updates(values)
-	(*bot.Users)[user.ID] = *user
+	bot.cacheUser(user)
+}
+
+// cacheUser stores an updated user in the user cache. Users who left are only updated if they're
+// still cached, so that they aren't relayed messages again.
+func (bot *SecretSquirrel) cacheUser(user *database.User) {
+	if !user.Left.Valid {
+		bot.Users.set(*user)
+		return
+	}
+
+	bot.Users.update(user.ID, func(u *database.User) {
+		*u = *user
+	})
+}
+
+// stopReceiving stops accepting new updates from telegram.
+func (bot *SecretSquirrel) stopReceiving() {
+	if bot.webhook == nil {
+		bot.Api.StopReceivingUpdates()
+		return
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := bot.webhook.Shutdown(timeout); err != nil {
+		bot.Log.WithError(err).Error("failed to stop the webhook server.")
+	}
+}
+
+// drainUpdates handles the updates that were received but not handled yet. It must be called after
+// stopReceiving: telegram won't send webhook updates that were already accepted again.
+func (bot *SecretSquirrel) drainUpdates(updates tgbotapi.UpdatesChannel) {
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return
+			}
+			bot.handleUpdate(u)
+		default:
+			return
+		}
+	}
+}
+
+// Shutdown relays everything that is still queued and releases the bot's resources.
+// Jobs the workers couldn't relay before the timeout stay in the database for the next start.
+func (bot *SecretSquirrel) Shutdown(timeout time.Duration) {
+	bot.Albums.flushAll()
+	bot.Queue.close()
+
+	done := make(chan struct{})
+	go func() {
+		bot.Queue.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		bot.Log.Warn("shutdown: timed out waiting for the relay queue to drain.")
+		// the workers still use the database, let them finish the sends they started first.
+		bot.Queue.cancel()
+		<-done
+	}
+
+	bot.Scheduler.Stop()
+	bot.Cache.flushMappings()
+
+	if bot.metrics != nil {
+		bot.metrics.Close()
+	}
+
+	// the rest of the message cache is written through on every change, so closing the database is all that's left.
+	sqlDB, err := bot.Db.DB()
+	if err != nil {
+		bot.Log.WithError(err).Error("failed to close the database.")
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		bot.Log.WithError(err).Error("failed to close the database.")
+	}
 }
 
-func initBot() *SecretSquirrel {
+func initBot(log *logrus.Logger) *SecretSquirrel {
+	driver, dsn := cfg.Bot.Database()
+	db := database.InitDB(driver, dsn, log)
+
+	// bring the schema up to date before anything touches the database.
+	version, err := database.MigrateUp(db)
+	if err != nil {
+		log.WithError(err).WithField("version", version).Panic("failed to migrate database.")
+	}
+	log.WithField("version", version).Info("database schema is up to date.")
+
+	api, err := telegram.NewAPI(cfg.Bot.Token)
+	if err != nil {
+		log.WithError(err).Panic("failed to connect to telegram.")
+	}
+
+	return NewSecretSquirrel(api, db, log)
+}
+
+// NewSecretSquirrel sets up the bot on top of an already opened database and telegram API.
+// Tests can pass a *telegram.Fake to run the bot without network access.
+func NewSecretSquirrel(api telegram.API, db *gorm.DB, log *logrus.Logger) *SecretSquirrel {
 	var (
-		bot *SecretSquirrel = &SecretSquirrel{}
+		bot *SecretSquirrel = &SecretSquirrel{Api: api, Db: db, Log: log}
 		err error
 	)
 
-	bot.Db = database.InitDB(cfg.Bot.DatabasePath)
-	bot.Api, err = tgbotapi.NewBotAPI(cfg.Bot.Token)
-	if err != nil {
-		log.Panic(err)
+	bot.Cache = NewMessageCache(bot.Db, bot.Log)
+	if err := bot.Cache.load(); err != nil {
+		log.WithError(err).Panic("failed to load the message cache.")
 	}
 
-	bot.Cache = NewMessageCache()
-
 	// create message queue and start workers.
-	bot.Queue = &Queue{ch: make(chan *QueueJob), mu: sync.Mutex{}}
-	for i := 0; i < MaxWorkerPool; i++ {
-		go worker(i, bot.Queue.ch)
+	bot.Limiter = NewRateLimiter(cfg.Queue.GlobalRateLimit, cfg.Queue.ChatRateLimit)
+	bot.Queue = NewQueue(bot.Db, bot.Log)
+	for i := 0; i < cfg.Queue.Workers; i++ {
+		bot.Queue.wg.Add(1)
+		go func(id int) {
+			defer bot.Queue.wg.Done()
+			worker(id, bot.Queue.ch)
+		}(i)
 	}
 
 	bot.UserQueue = NewPriorityQueue()
-	bot.Users = &UserCache{}
+	bot.Users = NewUserCache()
 
-	users, err := database.FindUsers(bot.Db, database.AreJoined)
+	users, err := database.FindUsers(bot.Db, database.AreJoined, database.ByLastActive)
 	if err != nil {
-		log.Panic("initApp: db query failed.")
+		log.WithError(err).Panic("initApp: db query failed.")
 	}
 
 	for _, u := range users {
-		(*bot.Users)[u.ID] = u
+		bot.Users.set(u)
 		bot.UserQueue.Add(u.ID)
 	}
 
@@ -272,14 +545,24 @@ func initBot() *SecretSquirrel {
 		scores: map[int64]float32{},
 	}
 
+	bot.Albums = NewAlbumBuffer(bot.handleAlbum)
+
 	bot.Scheduler = gocron.NewScheduler(time.UTC)
 	bot.Scheduler.Every(5).Seconds().Do(bot.Spam.expireTask)
 	bot.Scheduler.Every(6).Hours().Do(bot.Cache.expire)
+	bot.Scheduler.Every(1).Second().Do(bot.Cache.flushMappings)
+	bot.Scheduler.Every(15).Minutes().Do(bot.expireWarnings)
+	bot.Scheduler.Every(1).Second().Do(bot.retryTask)
 	bot.Scheduler.StartAsync()
 
 	return bot
 }
 
+// logUser returns a log entry for things concerning a user. The user's id is only logged hashed.
+func (bot *SecretSquirrel) logUser(userID int64) *logrus.Entry {
+	return bot.Log.WithField("user", logging.UserID(userID))
+}
+
 func genTripcode(tripcode string) []string {
 	var (
 		trimpass string
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"secretsquirrel/config"
+	"secretsquirrel/database"
+	"secretsquirrel/messages"
+	"secretsquirrel/telegram"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns a migrated sqlite database that is removed after the test.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := database.OpenDB(database.DriverSQLite, filepath.Join(t.TempDir(), "test.db"), newTestLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func newTestLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// newTestBot returns a bot on a fake telegram API, without workers or scheduled tasks.
+func newTestBot(t *testing.T) (*SecretSquirrel, *telegram.Fake) {
+	t.Helper()
+
+	var (
+		api = telegram.NewFake()
+		db  = newTestDB(t)
+		log = newTestLogger()
+	)
+
+	bot := &SecretSquirrel{
+		Api:       api,
+		Db:        db,
+		Users:     NewUserCache(),
+		Cache:     NewMessageCache(db, log),
+		UserQueue: NewPriorityQueue(),
+		Queue:     NewQueue(db, log),
+		Limiter:   NewRateLimiter(0, 0),
+		Log:       log,
+	}
+	return bot, api
+}
+
+// newRunningBot starts a bot with workers on a fake telegram API. The given users are in the chat.
+func newRunningBot(t *testing.T, users ...database.User) (*SecretSquirrel, *telegram.Fake) {
+	t.Helper()
+
+	old := cfg.Queue
+	cfg.Queue = config.QueueConfig{Workers: 2, GlobalRateLimit: 0, ChatRateLimit: 0}
+	t.Cleanup(func() { cfg.Queue = old })
+
+	var (
+		api = telegram.NewFake()
+		db  = newTestDB(t)
+	)
+
+	for _, u := range users {
+		u.Joined = time.Now()
+		u.LastActive = time.Now()
+		if err := db.Create(&u).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bot := NewSecretSquirrel(api, db, newTestLogger())
+	t.Cleanup(func() { bot.Shutdown(5 * time.Second) })
+	return bot, api
+}
+
+// receive pushes an update into the fake API, handles it like the main loop does and waits until
+// everything it queued was relayed.
+func receive(t *testing.T, bot *SecretSquirrel, api *telegram.Fake, u tgbotapi.Update) {
+	t.Helper()
+
+	api.Push(u)
+	bot.drainUpdates(api.GetUpdatesChan(tgbotapi.UpdateConfig{}))
+
+	waitFor(t, "the relay queue to drain", func() bool {
+		var n int64
+		if err := bot.Db.Model(&database.OutboundJob{}).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n == 0
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// textUpdate is a text message the user sent as messageID, replyTo is the id of the message
+// it replies to in the user's chat, or 0.
+func textUpdate(from int64, messageID int, text string, replyTo int) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		MessageID: messageID,
+		From:      &tgbotapi.User{ID: from},
+		Chat:      &tgbotapi.Chat{ID: from, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	if replyTo != 0 {
+		msg.ReplyToMessage = &tgbotapi.Message{MessageID: replyTo, Chat: msg.Chat}
+	}
+
+	return tgbotapi.Update{Message: msg}
+}
+
+// sentMessages returns the text messages sent to a chat, relayed copies and system messages alike.
+func sentMessages(api *telegram.Fake, chatID int64) []tgbotapi.MessageConfig {
+	var msgs []tgbotapi.MessageConfig
+
+	for _, c := range api.SentTo(chatID) {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			msgs = append(msgs, m)
+		case *tgbotapi.MessageConfig:
+			msgs = append(msgs, *m)
+		}
+	}
+	return msgs
+}
+
+// lastMessage returns the last text message sent to a chat.
+func lastMessage(t *testing.T, api *telegram.Fake, chatID int64) tgbotapi.MessageConfig {
+	t.Helper()
+
+	msgs := sentMessages(api, chatID)
+	if len(msgs) == 0 {
+		t.Fatalf("nothing was sent to %d", chatID)
+	}
+	return msgs[len(msgs)-1]
+}
+
+// deletedIDs returns the ids of the messages deleted in a chat.
+func deletedIDs(api *telegram.Fake, chatID int64) []int {
+	var ids []int
+
+	for _, c := range api.SentTo(chatID) {
+		if d, ok := c.(tgbotapi.DeleteMessageConfig); ok {
+			ids = append(ids, d.MessageID)
+		}
+	}
+	return ids
+}
+
+func TestRelayMessage(t *testing.T) {
+	bot, api := newRunningBot(t,
+		database.User{ID: 10, Rank: database.RankUser},
+		database.User{ID: 20, Rank: database.RankUser},
+		database.User{ID: 30, Rank: database.RankUser},
+	)
+
+	receive(t, bot, api, textUpdate(10, 100, "hello", 0))
+
+	for _, uid := range []int64{20, 30} {
+		msgs := sentMessages(api, uid)
+		if len(msgs) != 1 || msgs[0].Text != "hello" {
+			t.Errorf("user %d received %+v, want one copy of the message", uid, msgs)
+		}
+	}
+	if sent := api.SentTo(10); len(sent) != 0 {
+		t.Errorf("sender received %d messages, want none", len(sent))
+	}
+}
+
+func TestRelayReply(t *testing.T) {
+	bot, api := newRunningBot(t,
+		database.User{ID: 10, Rank: database.RankUser},
+		database.User{ID: 20, Rank: database.RankUser},
+		database.User{ID: 30, Rank: database.RankUser},
+	)
+
+	receive(t, bot, api, textUpdate(10, 100, "hello", 0))
+	copy20, copy30 := api.MessageIDs(20)[0], api.MessageIDs(30)[0]
+
+	receive(t, bot, api, textUpdate(20, 200, "hi", copy20))
+
+	// every copy of the reply answers the recipient's own copy of the message.
+	for uid, want := range map[int64]int{10: 100, 30: copy30} {
+		reply := lastMessage(t, api, uid)
+		if reply.Text != "hi" || reply.ReplyToMessageID != want {
+			t.Errorf("user %d received %q as a reply to %d, want %q as a reply to %d", uid, reply.Text, reply.ReplyToMessageID, "hi", want)
+		}
+	}
+	if msgs := sentMessages(api, 20); len(msgs) != 1 {
+		t.Errorf("replying user received %d messages, want only the original", len(msgs))
+	}
+}
+
+func TestKarma(t *testing.T) {
+	bot, api := newRunningBot(t,
+		database.User{ID: 10, Rank: database.RankUser},
+		database.User{ID: 20, Rank: database.RankUser},
+	)
+
+	receive(t, bot, api, textUpdate(10, 100, "hello", 0))
+	receive(t, bot, api, textUpdate(20, 200, "+1", api.MessageIDs(20)[0]))
+
+	if msg := lastMessage(t, api, 20); msg.Text != messages.KarmaThankMessage {
+		t.Errorf("voter was sent %q, want %q", msg.Text, messages.KarmaThankMessage)
+	}
+	if msg := lastMessage(t, api, 10); msg.Text != messages.KarmaNotificationMessage || msg.ReplyToMessageID != 100 {
+		t.Errorf("author was sent %q as a reply to %d, want the karma notification as a reply to 100", msg.Text, msg.ReplyToMessageID)
+	}
+	if n := len(sentMessages(api, 10)); n != 1 {
+		t.Errorf("author received %d messages, want only the karma notification", n)
+	}
+
+	user, err := database.FindUser(bot.Db, database.ByID(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Karma != cfg.Karma.KarmaPlusOne {
+		t.Errorf("author has %d karma, want %d", user.Karma, cfg.Karma.KarmaPlusOne)
+	}
+}
+
+func TestWarnAndDelete(t *testing.T) {
+	bot, api := newRunningBot(t,
+		database.User{ID: 10, Rank: database.RankUser},
+		database.User{ID: 20, Rank: database.RankUser},
+		database.User{ID: 30, Rank: database.RankMod},
+	)
+	cooldownNotice := strings.Split(messages.GivenCooldownMessage, "%s")[0]
+
+	receive(t, bot, api, textUpdate(10, 100, "first", 0))
+	receive(t, bot, api, textUpdate(20, 200, "second", 0))
+	first30, second30, second10 := api.MessageIDs(30)[0], api.MessageIDs(30)[1], api.MessageIDs(10)[0]
+
+	// /warn only hands out a cooldown, the message stays up.
+	receive(t, bot, api, textUpdate(30, 300, "/warn", first30))
+
+	if msg := lastMessage(t, api, 10); !strings.HasPrefix(msg.Text, cooldownNotice) || msg.ReplyToMessageID != 100 {
+		t.Errorf("warned author was sent %q as a reply to %d, want a cooldown notice as a reply to 100", msg.Text, msg.ReplyToMessageID)
+	}
+
+	// a second /warn of the same message is refused.
+	receive(t, bot, api, textUpdate(30, 301, "/warn", first30))
+
+	if msg := lastMessage(t, api, 30); msg.Text != messages.AlreadyWarnedError {
+		t.Errorf("moderator was sent %q, want %q", msg.Text, messages.AlreadyWarnedError)
+	}
+
+	// /delete hands out a cooldown and deletes every copy, including the author's own message.
+	receive(t, bot, api, textUpdate(30, 302, "/delete", second30))
+
+	if msg := lastMessage(t, api, 20); !strings.HasPrefix(msg.Text, cooldownNotice) || msg.ReplyToMessageID != 200 {
+		t.Errorf("deleted author was sent %q as a reply to %d, want a cooldown notice as a reply to 200", msg.Text, msg.ReplyToMessageID)
+	}
+
+	want := map[int64]int{10: second10, 20: 200, 30: second30}
+	waitFor(t, "the copies to be deleted", func() bool {
+		for uid := range want {
+			if len(deletedIDs(api, uid)) == 0 {
+				return false
+			}
+		}
+		return true
+	})
+	for uid, id := range want {
+		if got := deletedIDs(api, uid); len(got) != 1 || got[0] != id {
+			t.Errorf("deleted %v for user %d, want [%d]", got, uid, id)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"secretsquirrel/database"
+	"secretsquirrel/logging"
 	"sync"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/zoumo/goset"
+	"gorm.io/gorm"
 )
 
 type Counter struct {
@@ -37,14 +39,105 @@ func (um *UserMap) In(uid userID) bool {
 	return false
 }
 
-type UserCache map[userID]database.User
+// UserCache keeps the users that are in the chat. It's shared by the update loop, the workers and
+// the scheduler, so it must only be used through its methods.
+type UserCache struct {
+	mu    sync.RWMutex
+	users map[userID]database.User
+}
+
+func NewUserCache() *UserCache {
+	return &UserCache{
+		mu:    sync.RWMutex{},
+		users: make(map[userID]database.User),
+	}
+}
+
+// get returns a copy of a cached user.
+func (uc *UserCache) get(uid userID) (database.User, bool) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+
+	user, ok := uc.users[uid]
+	return user, ok
+}
+
+func (uc *UserCache) has(uid userID) bool {
+	_, ok := uc.get(uid)
+	return ok
+}
+
+func (uc *UserCache) set(user database.User) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	uc.users[user.ID] = user
+}
+
+// update changes a cached user in place. It does nothing if the user isn't cached, e.g. because they left.
+func (uc *UserCache) update(uid userID, fn func(user *database.User)) bool {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	user, ok := uc.users[uid]
+	if !ok {
+		return false
+	}
+	fn(&user)
+	uc.users[uid] = user
+	return true
+}
+
+func (uc *UserCache) delete(uid userID) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	delete(uc.users, uid)
+}
+
+func (uc *UserCache) size() int {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+
+	return len(uc.users)
+}
+
+// snapshot returns copies of the given users, in the same order. Users that aren't cached are left out.
+func (uc *UserCache) snapshot(uids []userID) []database.User {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+
+	users := make([]database.User, 0, len(uids))
+	for _, uid := range uids {
+		if user, ok := uc.users[uid]; ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// all returns copies of every cached user.
+func (uc *UserCache) all() []database.User {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+
+	users := make([]database.User, 0, len(uc.users))
+	for _, user := range uc.users {
+		users = append(users, user)
+	}
+	return users
+}
 
 // CachedMessage
 type CachedMessage struct {
-	userID  userID
-	time    time.Time
-	warned  bool
-	upvoted goset.Set
+	userID   userID
+	time     time.Time
+	warned   bool
+	reported bool
+	signed   bool
+	tripcode bool
+	official string
+	upvoted  goset.Set
 }
 
 func (cm *CachedMessage) isExpired() bool {
@@ -59,30 +152,102 @@ func (cm *CachedMessage) addUpvote(user int64) {
 	cm.upvoted.Add(user)
 }
 
-// MessageCache
+// MessageCache keeps cached messages in memory and writes every change through to the database
+// so that replies and moderation keep working across restarts.
 type MessageCache struct {
 	mu       sync.RWMutex
+	db       *gorm.DB
+	log      *logrus.Logger
 	counter  Counter
 	messages map[messageID]*CachedMessage
 	userMap  UserMap
+	// pending are mappings that haven't been written to the database yet, see flushMappings.
+	pending []database.CachedMapping
+	// flushMu keeps a flush from writing mappings back while they're being deleted.
+	flushMu sync.Mutex
 }
 
 func (ch *MessageCache) newMessage(ctx *BotContext) int {
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
-
 	count := ch.counter.Next()
 
-	ch.messages[*count] = &CachedMessage{
-		userID:  ctx.Message.From.ID,
-		time:    time.Now(),
-		warned:  false,
-		upvoted: goset.NewSet(),
+	cm := &CachedMessage{
+		userID:   ctx.Message.From.ID,
+		time:     time.Now(),
+		warned:   false,
+		signed:   ctx.Signed,
+		tripcode: ctx.Tripcode,
+		official: ctx.Official,
+		upvoted:  goset.NewSet(),
+	}
+	ch.messages[*count] = cm
+	ch.mu.Unlock()
+
+	err := database.SaveCachedMessage(ch.db, &database.CachedMessage{
+		ID:       *count,
+		UserID:   cm.userID,
+		Time:     cm.time,
+		Warned:   cm.warned,
+		Signed:   cm.signed,
+		Tripcode: cm.tripcode,
+		Official: cm.official,
+	})
+	if err != nil {
+		ch.log.WithError(err).WithField("message", *count).Error("failed to save cached message.")
 	}
 
 	return *count
 }
 
+// setWarned marks a cached message as warned.
+func (ch *MessageCache) setWarned(msid messageID) {
+	ch.mu.Lock()
+	if cm, ok := ch.messages[msid]; ok {
+		cm.warned = true
+	}
+	ch.mu.Unlock()
+
+	if err := database.SetCachedMessageWarned(ch.db, msid); err != nil {
+		ch.log.WithError(err).WithField("message", msid).Error("failed to save cached message.")
+	}
+}
+
+// setReported marks a cached message as reported.
+func (ch *MessageCache) setReported(msid messageID) {
+	ch.mu.Lock()
+	if cm, ok := ch.messages[msid]; ok {
+		cm.reported = true
+	}
+	ch.mu.Unlock()
+
+	if err := database.SetCachedMessageReported(ch.db, msid); err != nil {
+		ch.log.WithError(err).WithField("message", msid).Error("failed to save cached message.")
+	}
+}
+
+// addUpvote records an upvote from uid on a cached message.
+func (ch *MessageCache) addUpvote(msid messageID, uid userID) {
+	ch.mu.Lock()
+	if cm, ok := ch.messages[msid]; ok {
+		cm.addUpvote(uid)
+	}
+	ch.mu.Unlock()
+
+	if err := database.SaveCachedUpvote(ch.db, msid, uid); err != nil {
+		ch.log.WithError(err).WithFields(logrus.Fields{
+			"message": msid,
+			"user":    logging.UserID(uid),
+		}).Error("failed to save upvote.")
+	}
+}
+
+func (ch *MessageCache) size() int {
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	return len(ch.messages)
+}
+
 func (ch *MessageCache) getMessage(msid messageID) (*CachedMessage, error) {
 	ch.mu.RLock()
 	defer ch.mu.RUnlock()
@@ -95,6 +260,8 @@ func (ch *MessageCache) getMessage(msid messageID) (*CachedMessage, error) {
 	return cm, errors.New("cached Message not found")
 }
 
+// saveMapping records the telegram message id a user received a cached message as. It's written to the
+// database with the next flushMappings, so relaying a message to every user doesn't write one row at a time.
 func (ch *MessageCache) saveMapping(uid userID, msid messageID, data int) {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
@@ -103,15 +270,50 @@ func (ch *MessageCache) saveMapping(uid userID, msid messageID, data int) {
 		ch.userMap[uid] = MessageMap{}
 	}
 	ch.userMap[uid][msid] = data
+	ch.pending = append(ch.pending, database.CachedMapping{UserID: uid, MessageID: msid, MappedID: data})
 }
 
-func (ch *MessageCache) deleteMappings(msid messageID) {
+// flushMappings writes the mappings saved since the last flush to the database.
+func (ch *MessageCache) flushMappings() {
+	ch.flushMu.Lock()
+	defer ch.flushMu.Unlock()
+
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	pending := ch.pending
+	ch.pending = nil
+	ch.mu.Unlock()
+
+	if err := database.SaveCachedMappings(ch.db, pending); err != nil {
+		ch.log.WithError(err).WithField("count", len(pending)).Error("failed to save message mappings.")
+	}
+}
 
+// dropPending forgets unwritten mappings of the given messages, so a flush doesn't bring back deleted ones.
+// ch.mu must be held.
+func (ch *MessageCache) dropPending(msids goset.Set) {
+	pending := ch.pending[:0]
+	for _, m := range ch.pending {
+		if !msids.Contains(m.MessageID) {
+			pending = append(pending, m)
+		}
+	}
+	ch.pending = pending
+}
+
+func (ch *MessageCache) deleteMappings(msid messageID) {
+	ch.flushMu.Lock()
+	defer ch.flushMu.Unlock()
+
+	ch.mu.Lock()
 	for _, v := range ch.userMap {
 		delete(v, msid)
 	}
+	ch.dropPending(goset.NewSet(msid))
+	ch.mu.Unlock()
+
+	if err := database.DeleteCachedMappings(ch.db, msid); err != nil {
+		ch.log.WithError(err).WithField("message", msid).Error("failed to delete message mappings.")
+	}
 }
 
 // LookupCacheMessage takes a messageID and returns the value for that key from MessageCache.UserMap
@@ -160,33 +362,101 @@ func (ch *MessageCache) lookupCacheMessageKey(uid userID, msid messageID) (int,
 	return mappedKey, nil
 }
 
-// TODO: scheduling for expire()
 func (ch *MessageCache) expire() goset.Set {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	var (
+		expired = goset.NewSet()
+		ids     []int
+	)
 
-	expired := goset.NewSet()
+	ch.flushMu.Lock()
+	defer ch.flushMu.Unlock()
 
+	ch.mu.Lock()
 	for k, v := range ch.messages {
 		if !v.isExpired() {
 			continue
 		}
 
 		expired.Add(k)
+		ids = append(ids, k)
 		delete(ch.messages, k)
-		ch.deleteMappings(k)
+		for _, m := range ch.userMap {
+			delete(m, k)
+		}
 	}
 
+	ch.dropPending(expired)
+	counter := ch.counter.count
+	ch.mu.Unlock()
+
 	if l := expired.Len(); l > 0 {
-		fmt.Printf("Expired %d entries from cache", l)
+		if err := database.DeleteCachedMessages(ch.db, ids...); err != nil {
+			ch.log.WithError(err).Error("failed to delete expired cached messages.")
+		}
+		// ids are only taken from the remaining messages on the next start, keep counting past the expired ones.
+		if err := database.SetCacheCounter(ch.db, counter); err != nil {
+			ch.log.WithError(err).Error("failed to save the cache counter.")
+		}
+		ch.log.WithField("count", l).Info("expired entries from cache.")
 	}
 
 	return expired
 }
 
-func NewMessageCache() *MessageCache {
+// load restores the cache from the database. Entries that expired while the bot was offline are dropped.
+func (ch *MessageCache) load() error {
+	ch.mu.Lock()
+
+	cms, upvotes, mappings, err := database.LoadCache(ch.db)
+	if err != nil {
+		ch.mu.Unlock()
+		return err
+	}
+	if ch.counter.count, err = database.GetCacheCounter(ch.db); err != nil {
+		ch.mu.Unlock()
+		return err
+	}
+
+	for _, c := range cms {
+		ch.messages[c.ID] = &CachedMessage{
+			userID:   c.UserID,
+			time:     c.Time,
+			warned:   c.Warned,
+			reported: c.Reported,
+			signed:   c.Signed,
+			tripcode: c.Tripcode,
+			official: c.Official,
+			upvoted:  goset.NewSet(),
+		}
+		if c.ID >= ch.counter.count {
+			ch.counter.count = c.ID + 1
+		}
+	}
+
+	for _, u := range upvotes {
+		if cm, ok := ch.messages[u.MessageID]; ok {
+			cm.addUpvote(u.UserID)
+		}
+	}
+
+	for _, m := range mappings {
+		if !ch.userMap.In(m.UserID) {
+			ch.userMap[m.UserID] = MessageMap{}
+		}
+		ch.userMap[m.UserID][m.MessageID] = m.MappedID
+	}
+
+	ch.mu.Unlock()
+
+	ch.expire()
+	return nil
+}
+
+func NewMessageCache(db *gorm.DB, log *logrus.Logger) *MessageCache {
 	return &MessageCache{
 		mu:       sync.RWMutex{},
+		db:       db,
+		log:      log,
 		counter:  Counter{},
 		messages: make(map[int]*CachedMessage),
 		userMap:  make(UserMap),
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMessageCacheReload(t *testing.T) {
+	db := newTestDB(t)
+	log := newTestLogger()
+
+	ch := NewMessageCache(db, log)
+	ctx := &BotContext{
+		Message:  &tgbotapi.Message{From: &tgbotapi.User{ID: 10}},
+		Signed:   true,
+		Official: "mod",
+	}
+	msid := ch.newMessage(ctx)
+	ch.saveMapping(10, msid, 100)
+	ch.saveMapping(20, msid, 200)
+	ch.setWarned(msid)
+	ch.addUpvote(msid, 20)
+	ch.flushMappings()
+
+	reloaded := NewMessageCache(db, log)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := reloaded.getMessage(msid)
+	if err != nil {
+		t.Fatalf("message %d wasn't reloaded: %v", msid, err)
+	}
+	if cm.userID != 10 || !cm.warned || !cm.signed || cm.official != "mod" {
+		t.Errorf("reloaded message = %+v", cm)
+	}
+	if !cm.hasUpvoted(20) {
+		t.Error("upvote wasn't reloaded")
+	}
+	for uid, want := range map[int64]int{10: 100, 20: 200} {
+		if got, err := reloaded.lookupCacheMessageValue(uid, msid); err != nil || got != want {
+			t.Errorf("mapping of user %d = %d, %v; want %d", uid, got, err, want)
+		}
+	}
+
+	if next := reloaded.newMessage(ctx); next != msid+1 {
+		t.Errorf("next id after reload = %d, want %d", next, msid+1)
+	}
+}
+
+func TestMessageCacheCounterSurvivesExpiry(t *testing.T) {
+	db := newTestDB(t)
+	log := newTestLogger()
+
+	ch := NewMessageCache(db, log)
+	ctx := &BotContext{Message: &tgbotapi.Message{From: &tgbotapi.User{ID: 10}}}
+	var last int
+	for i := 0; i < 3; i++ {
+		last = ch.newMessage(ctx)
+		ch.saveMapping(10, last, i)
+	}
+
+	// age every message past the cache lifetime.
+	ch.mu.Lock()
+	for _, cm := range ch.messages {
+		cm.time = time.Now().Add(-25 * time.Hour)
+	}
+	ch.mu.Unlock()
+
+	if expired := ch.expire(); expired.Len() != 3 {
+		t.Fatalf("expired %d messages, want 3", expired.Len())
+	}
+	// the mappings were never written, they must not come back with a flush.
+	ch.flushMappings()
+
+	reloaded := NewMessageCache(db, log)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := reloaded.size(); n != 0 {
+		t.Errorf("reloaded %d expired messages", n)
+	}
+	if _, err := reloaded.lookupCacheMessageValue(10, last); err == nil {
+		t.Error("mapping of an expired message was reloaded")
+	}
+	if next := reloaded.newMessage(ctx); next != last+1 {
+		t.Errorf("next id after every message expired = %d, want %d", next, last+1)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"secretsquirrel/database"
+	"secretsquirrel/messages"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// CallbackExpiry is how long inline keyboard buttons keep working. Messages leave the cache after a day,
+	// so buttons that act on them are useless after that anyway.
+	CallbackExpiry = 24 * time.Hour
+	// telegram rejects buttons with more than 64 bytes of callback data.
+	maxCallbackDataLength = 64
+)
+
+var (
+	errCallbackInvalid = errors.New("callback data is invalid")
+	errCallbackExpired = errors.New("callback data has expired")
+)
+
+// BotCallback handles presses of inline keyboard buttons created with callbackButton.
+// The handler must answer the callback query, e.g. with bot.answerCallback.
+type BotCallback struct {
+	// Rank is the lowest rank allowed to press the button.
+	Rank    database.UserRank
+	Handler func(*SecretSquirrel, *BotContext, []string)
+}
+
+var BotCallbacks = map[string]BotCallback{
+	"report": {Rank: database.RankMod, Handler: cbReport},
+}
+
+// callbackButton creates a button that runs the named callback with the given args when pressed.
+// The args must not contain ':' or '|'.
+func callbackButton(text string, name string, args ...string) (tgbotapi.InlineKeyboardButton, error) {
+	data, err := encodeCallbackData(name, args, time.Now().Add(CallbackExpiry))
+	if err != nil {
+		return tgbotapi.InlineKeyboardButton{}, err
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data), nil
+}
+
+// encodeCallbackData packs a callback as "name:arg:arg|expiry|signature".
+func encodeCallbackData(name string, args []string, expiry time.Time) (string, error) {
+	payload := strings.Join(append([]string{name}, args...), ":") + "|" + strconv.FormatInt(expiry.Unix(), 36)
+	data := payload + "|" + signCallbackPayload(payload)
+
+	if len(data) > maxCallbackDataLength {
+		return "", fmt.Errorf("callback data of %s is too long (%d bytes)", name, len(data))
+	}
+	return data, nil
+}
+
+// decodeCallbackData verifies callback data and returns the callback's name and args.
+func decodeCallbackData(data string) (string, []string, error) {
+	i := strings.LastIndex(data, "|")
+	if i == -1 {
+		return "", nil, errCallbackInvalid
+	}
+
+	payload, sig := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signCallbackPayload(payload))) {
+		return "", nil, errCallbackInvalid
+	}
+
+	j := strings.LastIndex(payload, "|")
+	if j == -1 {
+		return "", nil, errCallbackInvalid
+	}
+
+	expiry, err := strconv.ParseInt(payload[j+1:], 36, 64)
+	if err != nil {
+		return "", nil, errCallbackInvalid
+	}
+	if time.Now().After(time.Unix(expiry, 0)) {
+		return "", nil, errCallbackExpired
+	}
+
+	fields := strings.Split(payload[:j], ":")
+	return fields[0], fields[1:], nil
+}
+
+func signCallbackPayload(payload string) string {
+	key := cfg.Bot.CallbackSecret
+	if key == "" {
+		key = cfg.Bot.Token
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	// 8 bytes are plenty to make forging a button impractical and keep the data short.
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// handleCallback dispatches presses of inline keyboard buttons to their BotCallback.
+func (bot *SecretSquirrel) handleCallback(ctx *BotContext) {
+	name, args, err := decodeCallbackData(ctx.Callback.Data)
+	if err != nil {
+		if err == errCallbackExpired {
+			bot.answerCallback(ctx, messages.CallbackExpiredError)
+			return
+		}
+		bot.logUser(ctx.Callback.From.ID).WithError(err).Warn("rejected callback query.")
+		bot.answerCallback(ctx, "")
+		return
+	}
+
+	cb, ok := BotCallbacks[name]
+	if !ok {
+		bot.answerCallback(ctx, "")
+		return
+	}
+
+	if ctx.User == nil || ctx.User.Left.Valid || ctx.User.Rank < cb.Rank {
+		bot.answerCallback(ctx, messages.NotAllowedError)
+		return
+	}
+
+	cb.Handler(bot, ctx, args)
+}
+
+// answerCallback answers the callback query of a button press. The text is shown to the user as a notification, if any.
+func (bot *SecretSquirrel) answerCallback(ctx *BotContext, text string) {
+	if _, err := bot.Api.Request(tgbotapi.NewCallback(ctx.Callback.ID, text)); err != nil {
+		bot.logUser(ctx.Callback.From.ID).WithError(err).Warn("failed to answer callback query.")
+	}
+}
+
+// callbackEdit returns the base of an edit to the message whose button was pressed.
+func callbackEdit(ctx *BotContext) (tgbotapi.BaseEdit, bool) {
+	switch {
+	case ctx.Callback.Message != nil:
+		return tgbotapi.BaseEdit{ChatID: ctx.Callback.Message.Chat.ID, MessageID: ctx.Callback.Message.MessageID}, true
+	case ctx.Callback.InlineMessageID != "":
+		return tgbotapi.BaseEdit{InlineMessageID: ctx.Callback.InlineMessageID}, true
+	}
+	return tgbotapi.BaseEdit{}, false
+}
+
+// editCallbackMessage replaces the text and keyboard of the message whose button was pressed.
+// A nil keyboard removes the buttons.
+func (bot *SecretSquirrel) editCallbackMessage(ctx *BotContext, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	base, ok := callbackEdit(ctx)
+	if !ok {
+		return
+	}
+	base.ReplyMarkup = keyboard
+
+	edit := tgbotapi.EditMessageTextConfig{BaseEdit: base, Text: text, ParseMode: "HTML"}
+	if _, err := bot.Api.Send(edit); err != nil {
+		bot.logUser(ctx.Callback.From.ID).WithError(err).Warn("failed to edit callback message.")
+	}
+}
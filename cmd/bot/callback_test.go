@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCallbackSecret signs callbacks with secret for the rest of the test.
+func withCallbackSecret(t *testing.T, secret string) {
+	t.Helper()
+
+	old := cfg.Bot.CallbackSecret
+	cfg.Bot.CallbackSecret = secret
+	t.Cleanup(func() { cfg.Bot.CallbackSecret = old })
+}
+
+func TestCallbackDataRoundTrip(t *testing.T) {
+	withCallbackSecret(t, "secret")
+
+	data, err := encodeCallbackData("report", []string{"warn", "42"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, args, err := decodeCallbackData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "report" || len(args) != 2 || args[0] != "warn" || args[1] != "42" {
+		t.Errorf("decoded %q %q", name, args)
+	}
+}
+
+func TestCallbackDataRejected(t *testing.T) {
+	withCallbackSecret(t, "secret")
+
+	valid, err := encodeCallbackData("report", []string{"warn", "42"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := encodeCallbackData("report", []string{"warn", "42"}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Bot.CallbackSecret = "other secret"
+	forged, err := encodeCallbackData("report", []string{"warn", "42"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Bot.CallbackSecret = "secret"
+
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"expired", expired, errCallbackExpired},
+		{"signed with another secret", forged, errCallbackInvalid},
+		{"changed args", strings.Replace(valid, "warn", "dismiss", 1), errCallbackInvalid},
+		{"missing signature", valid[:strings.LastIndex(valid, "|")], errCallbackInvalid},
+		{"no separators", "report:warn:42", errCallbackInvalid},
+		{"empty", "", errCallbackInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCallbackData(tt.data); err != tt.err {
+				t.Errorf("decodeCallbackData(%q) = %v, want %v", tt.data, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCallbackDataTooLong(t *testing.T) {
+	withCallbackSecret(t, "secret")
+
+	if _, err := encodeCallbackData("report", []string{strings.Repeat("x", maxCallbackDataLength)}, time.Now()); err == nil {
+		t.Error("encoded callback data longer than telegram allows")
+	}
+}
@@ -2,19 +2,23 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"secretsquirrel/messages"
 	"strings"
 	"time"
 
 	"secretsquirrel/database"
+	"secretsquirrel/logging"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
 
 var BotCommands = map[string]func(*SecretSquirrel, *BotContext){
 	"start":          cmdStart,
+	"stop":           cmdStop,
 	"users":          cmdUsers,
 	"info":           cmdInfo,
 	"sign":           cmdSignMessage,
@@ -24,15 +28,20 @@ var BotCommands = map[string]func(*SecretSquirrel, *BotContext){
 	"motd":           cmdMotd,
 	"setmotd":        cmdSetMotd,
 	"modhelp":        cmdModHelp,
+	"modsay":         cmdModSay,
+	"adminsay":       cmdAdminSay,
 	"adminhelp":      cmdAdminHelp,
 	"toggledebug":    cmdToggleDebug,
 	"toggleKarma":    cmdToggleKarma,
 	"toggletripcode": cmdToggleTripcode,
 	"tripcode":       cmdTripcode,
 	"blacklist":      cmdBlacklist,
+	"uncooldown":     cmdUncooldown,
+	"modlog":         cmdModLog,
 	"warn":           cmdWarn,
 	"delete":         cmdDelete,
 	"remove":         cmdRemove,
+	"report":         cmdReport,
 	"mod":            cmdPromoteMod,
 	"admin":          cmdPromoteAdmin,
 	"version":        cmdVersion,
@@ -48,25 +57,37 @@ func cmdStart(bot *SecretSquirrel, ctx *BotContext) {
 		}
 		bot.UpdateUser(ctx.User, "left", sql.NullTime{})
 		bot.UserQueue.Add(ctx.User.ID)
+		bot.logUser(ctx.User.ID).Info("user rejoined.")
 		cmdMotd(bot, ctx)
 		return
 	}
 
 	var count int64
-	bot.Db.Model(database.User{}).Where("rank = ?", database.RankAdmin).Count(&count)
+	bot.Db.Model(database.User{}).Scopes(database.ByRank(database.RankAdmin)).Count(&count)
 
 	user := database.NewUser(bot.Db, ctx.Message.From)
 	if count == 0 {
 		user.Rank = database.RankAdmin
 	}
 	bot.Db.Save(&user)
-	(*bot.Users)[user.ID] = *user
+	bot.Users.set(*user)
 	bot.UserQueue.Add(user.ID)
+	bot.logUser(user.ID).Info("user joined.")
 	cmdMotd(bot, ctx)
 }
 
+func cmdStop(bot *SecretSquirrel, ctx *BotContext) {
+	if ctx.User == nil || ctx.User.Left.Valid {
+		bot.sendSystemMessage(ctx.Message.From.ID, messages.UserNotInChatMessage)
+		return
+	}
+
+	bot.leaveUser(ctx.User)
+	bot.sendSystemMessage(ctx.User.ID, messages.UserLeftMessage)
+}
+
 func cmdUsers(bot *SecretSquirrel, ctx *BotContext) {
-	bot.sendSystemMessage(ctx.User.ID, fmt.Sprintf("<b>%d</b> users", len((*bot.Users))))
+	bot.sendSystemMessage(ctx.User.ID, fmt.Sprintf("<b>%d</b> users", bot.Users.size()))
 }
 
 func cmdInfo(bot *SecretSquirrel, ctx *BotContext) {
@@ -92,11 +113,11 @@ func cmdModInfo(bot *SecretSquirrel, ctx *BotContext) {
 
 	cm, err := bot.Cache.getMessage(ctx.ReplyID)
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(ctx.User.ID).WithError(err).Debug("reply is not in the message cache.")
 		return
 	}
 
-	if user, ok := (*bot.Users)[cm.userID]; !ok {
+	if user, ok := bot.Users.get(cm.userID); !ok {
 		bot.sendSystemMessageReply(ctx.Message.From.ID, "User not found", ctx.Message.MessageID)
 		return
 
@@ -116,11 +137,31 @@ func cmdSignMessage(bot *SecretSquirrel, ctx *BotContext) {
 		return
 	}
 
+	if ctx.User == nil || ctx.User.Left.Valid {
+		bot.sendSystemMessage(ctx.Message.From.ID, messages.UserNotInChatMessage)
+		return
+	}
+
+	// limit usage of /sign to once every SignLimitInterval seconds.
+	if cfg.Limits.SignLimitInterval > 0 && ctx.User.LastSigned.Valid {
+		if time.Since(ctx.User.LastSigned.Time) < time.Duration(cfg.Limits.SignLimitInterval)*time.Second {
+			bot.sendSystemMessageReply(ctx.User.ID, messages.SpamSignError, ctx.Message.MessageID)
+			return
+		}
+	}
+
 	ctx.Signed = true
-	err := bot.handleMessage(ctx)
+	queued, err := bot.handleMessage(ctx)
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(ctx.User.ID).WithError(err).Error("failed to handle message.")
+		return
+	}
+	// rejected messages don't count towards the limit.
+	if !queued {
+		return
 	}
+
+	bot.UpdateUser(ctx.User, "last_signed", sql.NullTime{Time: time.Now(), Valid: true})
 }
 
 func cmdTSign(bot *SecretSquirrel, ctx *BotContext) {
@@ -130,9 +171,38 @@ func cmdTSign(bot *SecretSquirrel, ctx *BotContext) {
 	}
 
 	ctx.Tripcode = true
-	err := bot.handleMessage(ctx)
+	_, err := bot.handleMessage(ctx)
+	if err != nil {
+		bot.logUser(ctx.Message.From.ID).WithError(err).Error("failed to handle message.")
+	}
+}
+
+func cmdModSay(bot *SecretSquirrel, ctx *BotContext) {
+	if ctx.User == nil || !ctx.User.IsPrivileged() {
+		return
+	}
+
+	sendOfficialMessage(bot, ctx, "mods")
+}
+
+func cmdAdminSay(bot *SecretSquirrel, ctx *BotContext) {
+	if ctx.User == nil || !ctx.User.IsAdmin() {
+		return
+	}
+
+	sendOfficialMessage(bot, ctx, "admins")
+}
+
+// sendOfficialMessage relays the command arguments as an official staff message tagged with the given rank.
+func sendOfficialMessage(bot *SecretSquirrel, ctx *BotContext, tag string) {
+	if strings.TrimSpace(ctx.Message.CommandArguments()) == "" {
+		return
+	}
+
+	ctx.Official = tag
+	_, err := bot.handleMessage(ctx)
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(ctx.Message.From.ID).WithError(err).Error("failed to handle message.")
 	}
 }
 
@@ -144,7 +214,7 @@ func cmdSetMotd(bot *SecretSquirrel, ctx *BotContext) {
 	motd := ctx.Message.CommandArguments()
 	err := database.SetMotd(bot.Db, motd)
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(ctx.User.ID).WithError(err).Error("failed to set the motd.")
 	}
 }
 
@@ -256,29 +326,49 @@ func cmdTripcode(bot *SecretSquirrel, ctx *BotContext) {
 }
 
 func cmdPromoteMod(bot *SecretSquirrel, ctx *BotContext) {
+	// must be an admin
+	if !ctx.User.IsAdmin() {
+		return
+	}
+
 	username := strings.Replace(ctx.Message.CommandArguments(), "@", "", -1)
 	user, err := database.FindUser(bot.Db, database.ByUsername(username))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			bot.sendSystemMessage(ctx.User.ID, messages.NoUserError)
+		} else {
+			bot.logUser(ctx.User.ID).WithError(err).Error("failed to find user.")
 		}
+		return
 	}
 
-	bot.UpdateUser(ctx.User, "rank", database.RankMod)
+	bot.UpdateUser(user, "rank", database.RankMod)
+	moderationActions.WithLabelValues("mod").Inc()
+	bot.recordModAction(database.ActionPromoteMod, ctx.User.ID, user.ID, "", -1)
 	bot.sendSystemMessage(user.ID, messages.PromotedModMessage)
 	bot.sendSystemMessageReply(ctx.User.ID, fmt.Sprintf("User Promoted to Mod: @%s", username), ctx.Message.MessageID)
 }
 
 func cmdPromoteAdmin(bot *SecretSquirrel, ctx *BotContext) {
+	// must be an admin
+	if !ctx.User.IsAdmin() {
+		return
+	}
+
 	username := strings.Replace(ctx.Message.CommandArguments(), "@", "", -1)
 	user, err := database.FindUser(bot.Db, database.ByUsername(username))
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			bot.sendSystemMessage(ctx.User.ID, messages.NoUserError)
+		} else {
+			bot.logUser(ctx.User.ID).WithError(err).Error("failed to find user.")
 		}
+		return
 	}
 
-	bot.UpdateUser(ctx.User, "rank", database.RankAdmin)
+	bot.UpdateUser(user, "rank", database.RankAdmin)
+	moderationActions.WithLabelValues("admin").Inc()
+	bot.recordModAction(database.ActionPromoteAdmin, ctx.User.ID, user.ID, "", -1)
 	bot.sendSystemMessage(user.ID, messages.PromotedAdminMessage)
 	bot.sendSystemMessageReply(ctx.User.ID, fmt.Sprintf("User Promoted to Admin: @%s", username), ctx.Message.MessageID)
 }
@@ -300,35 +390,9 @@ func cmdRemove(bot *SecretSquirrel, ctx *BotContext) {
 		return
 	}
 
-	cm, err := bot.Cache.getMessage(ctx.ReplyID)
-	if err != nil {
-		fmt.Println(err)
-		return
+	if err := bot.removeMessage(ctx.User, ctx.ReplyID, ctx.Message.CommandArguments()); err != nil {
+		bot.sendSystemMessageReply(ctx.User.ID, err.Error(), ctx.Message.MessageID)
 	}
-
-	bot.sendSystemMessageReply(cm.userID, messages.MessageDeletedMessage, ctx.ReplyID)
-
-	go func() {
-		for _, uindex := range bot.UserQueue.Get() {
-			var user_replyID int = -1
-			user := (*bot.Users)[uindex]
-
-			if ctx.ReplyID != -1 {
-				user_replyID, err = bot.Cache.lookupCacheMessageValue(user.ID, ctx.ReplyID)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-			}
-
-			// delete each message
-			// api might get mad if this deletes more than 30 messages.
-			if user_replyID != -1 {
-				bot.Api.Send(tgbotapi.NewDeleteMessage(user.ID, user_replyID))
-			}
-		}
-		bot.Cache.deleteMappings(ctx.ReplyID)
-	}()
 }
 
 func cmdDelete(bot *SecretSquirrel, ctx *BotContext) {
@@ -343,98 +407,107 @@ func cmdDelete(bot *SecretSquirrel, ctx *BotContext) {
 		return
 	}
 
-	cm, err := bot.Cache.getMessage(ctx.ReplyID)
-	if err != nil {
-		fmt.Println(err)
+	if err := bot.warnMessage(ctx.User, ctx.ReplyID, ctx.Message.CommandArguments(), true); err != nil {
+		bot.sendSystemMessageReply(ctx.User.ID, err.Error(), ctx.Message.MessageID)
 		return
 	}
+	bot.deleteCopies(ctx.ReplyID)
+}
 
-	// message already has a warning.
-	if cm.warned {
-		bot.sendSystemMessageReply(ctx.User.ID, messages.AlreadyWarnedError, ctx.Message.MessageID)
+func cmdWarn(bot *SecretSquirrel, ctx *BotContext) {
+	// must be mod.
+	if !ctx.User.IsPrivileged() {
 		return
 	}
 
-	user, err := database.FindUser(bot.Db, database.ByID(cm.userID))
-	if err != nil {
-		fmt.Println(err)
+	// reply required
+	if !ctx.IsReply() {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NoReplyError, ctx.Message.MessageID)
 		return
 	}
 
-	t := bot.AddWarning(cfg, user)
-	cm.warned = true
+	if err := bot.warnMessage(ctx.User, ctx.ReplyID, ctx.Message.CommandArguments(), false); err != nil {
+		bot.sendSystemMessageReply(ctx.User.ID, err.Error(), ctx.Message.MessageID)
+	}
+}
 
-	replyID, err := bot.Cache.lookupCacheMessageValue(cm.userID, ctx.ReplyID)
+// removeMessage deletes every copy of a cached message without warning its author.
+// The returned errors are meant to be shown to the moderator.
+func (bot *SecretSquirrel) removeMessage(actor *database.User, msid int, reason string) error {
+	cm, err := bot.Cache.getMessage(msid)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return errors.New(messages.NotInCacheError)
 	}
 
-	bot.sendSystemMessageReply(cm.userID, fmt.Sprintf(messages.GivenCooldownMessage, t), replyID)
-
-	go func() {
-		// echo message to all users.
-		for _, uindex := range bot.UserQueue.Get() {
-			var user_replyID int = -1
-			user := (*bot.Users)[uindex]
-
-			if ctx.ReplyID != -1 {
-				user_replyID, err = bot.Cache.lookupCacheMessageValue(user.ID, ctx.ReplyID)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-			}
+	if replyID, err := bot.Cache.lookupCacheMessageValue(cm.userID, msid); err == nil {
+		bot.sendSystemMessageReply(cm.userID, messages.MessageDeletedMessage, replyID)
+	}
+	moderationActions.WithLabelValues("remove").Inc()
+	bot.recordModAction(database.ActionRemove, actor.ID, cm.userID, reason, msid)
 
-			// delete each message
-			if user_replyID != -1 {
-				bot.Api.Send(tgbotapi.NewDeleteMessage(user.ID, user_replyID))
-			}
-		}
-		bot.Cache.deleteMappings(ctx.ReplyID)
-	}()
+	bot.deleteCopies(msid)
+	return nil
 }
 
-func cmdWarn(bot *SecretSquirrel, ctx *BotContext) {
-	// must be mod.
-	if !ctx.User.IsPrivileged() {
-		return
-	}
-
-	// reply required
-	if !ctx.IsReply() {
-		bot.sendSystemMessageReply(ctx.User.ID, messages.NoReplyError, ctx.Message.MessageID)
-		return
-	}
+// errAlreadyWarned is returned by warnMessage if the message already has a warning.
+var errAlreadyWarned = errors.New(messages.AlreadyWarnedError)
 
-	cm, err := bot.Cache.getMessage(ctx.ReplyID)
+// warnMessage warns the author of a cached message and hands them a cooldown. If delete is set, the warning is
+// recorded as a deletion, the copies of the message are deleted by the caller with deleteCopies.
+// The returned errors are meant to be shown to the moderator.
+func (bot *SecretSquirrel) warnMessage(actor *database.User, msid int, reason string, delete bool) error {
+	cm, err := bot.Cache.getMessage(msid)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return errors.New(messages.NotInCacheError)
 	}
 
 	// message already has a warning.
 	if cm.warned {
-		bot.sendSystemMessageReply(ctx.User.ID, messages.AlreadyWarnedError, ctx.Message.MessageID)
-		return
+		return errAlreadyWarned
 	}
 
 	user, err := database.FindUser(bot.Db, database.ByID(cm.userID))
 	if err != nil {
-		fmt.Println(err)
-		return
+		bot.logUser(cm.userID).WithError(err).Error("failed to find user.")
+		return err
 	}
 
 	t := bot.AddWarning(cfg, user)
-	cm.warned = true
+	bot.Cache.setWarned(msid)
+
+	if delete {
+		moderationActions.WithLabelValues("delete").Inc()
+		bot.recordModAction(database.ActionDelete, actor.ID, cm.userID, reason, msid)
+	} else {
+		moderationActions.WithLabelValues("warn").Inc()
+		bot.recordModAction(database.ActionWarn, actor.ID, cm.userID, reason, msid)
+	}
 
-	replyID, err := bot.Cache.lookupCacheMessageValue(cm.userID, ctx.ReplyID)
+	replyID, err := bot.Cache.lookupCacheMessageValue(cm.userID, msid)
 	if err != nil {
-		fmt.Println(err)
-		return
+		bot.logUser(cm.userID).WithError(err).Debug("message is not mapped for user.")
+	} else {
+		bot.sendSystemMessageReply(cm.userID, fmt.Sprintf(messages.GivenCooldownMessage, t), replyID)
 	}
+	return nil
+}
 
-	bot.sendSystemMessageReply(cm.userID, fmt.Sprintf(messages.GivenCooldownMessage, t), replyID)
+// deleteCopies deletes the copy of a cached message every user received.
+func (bot *SecretSquirrel) deleteCopies(msid int) {
+	go func() {
+		for _, user := range bot.Users.snapshot(bot.UserQueue.Get()) {
+			mappedID, err := bot.Cache.lookupCacheMessageValue(user.ID, msid)
+			if err != nil {
+				bot.logUser(user.ID).WithError(err).Debug("message is not mapped for user.")
+				continue
+			}
+
+			// api might get mad if this deletes more than 30 messages.
+			bot.Limiter.take(user.ID)
+			bot.Api.Send(tgbotapi.NewDeleteMessage(user.ID, mappedID))
+		}
+		bot.Cache.deleteMappings(msid)
+	}()
 }
 
 func cmdBlacklist(bot *SecretSquirrel, ctx *BotContext) {
@@ -453,23 +526,27 @@ func cmdBlacklist(bot *SecretSquirrel, ctx *BotContext) {
 
 	cm, err := bot.Cache.getMessage(ctx.ReplyID)
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(ctx.User.ID).WithError(err).Debug("reply is not in the message cache.")
 		return
 	}
 
 	user, err := database.FindUser(bot.Db, database.ByID(cm.userID))
 	if err != nil {
-		fmt.Println(err)
+		bot.logUser(cm.userID).WithError(err).Error("failed to find user.")
 		return
 	}
 
-	cm.warned = true
+	bot.Cache.setWarned(ctx.ReplyID)
 
 	bot.UpdatesUser(user, database.User{
 		Rank:            database.RankBanned,
 		Left:            sql.NullTime{Time: time.Now(), Valid: true},
 		BlacklistReason: reason,
 	})
+	bot.UserQueue.Remove(user.ID)
+	bot.Users.delete(user.ID)
+	moderationActions.WithLabelValues("blacklist").Inc()
+	bot.recordModAction(database.ActionBlacklist, ctx.User.ID, user.ID, reason, ctx.ReplyID)
 
 	replyText := fmt.Sprintf(messages.BlacklistedError, user.BlacklistReason)
 	if cfg.Bot.BlacklistContact != "" {
@@ -479,6 +556,150 @@ func cmdBlacklist(bot *SecretSquirrel, ctx *BotContext) {
 	bot.sendSystemMessage(user.ID, replyText)
 }
 
+func cmdUncooldown(bot *SecretSquirrel, ctx *BotContext) {
+	// must be an admin
+	if ctx.User == nil || !ctx.User.IsAdmin() {
+		return
+	}
+
+	args := strings.Fields(ctx.Message.CommandArguments())
+	if len(args) == 0 {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NoUserError, ctx.Message.MessageID)
+		return
+	}
+
+	user, err := bot.findUser(args[0])
+	if err != nil {
+		if len(args[0]) == 4 {
+			bot.sendSystemMessageReply(ctx.User.ID, messages.NoUserByIdError, ctx.Message.MessageID)
+		} else {
+			bot.sendSystemMessageReply(ctx.User.ID, messages.NoUserError, ctx.Message.MessageID)
+		}
+		return
+	}
+
+	if !user.IsInCooldown() {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NotInCooldownError, ctx.Message.MessageID)
+		return
+	}
+
+	warnings := user.Warnings
+	if len(args) > 1 && args[1] == "unwarn" && warnings > 0 {
+		warnings--
+	}
+
+	bot.UpdatesUser(user, map[string]interface{}{
+		"cooldown_until": sql.NullTime{},
+		"warnings":       warnings,
+	})
+	moderationActions.WithLabelValues("uncooldown").Inc()
+	bot.recordModAction(database.ActionUncooldown, ctx.User.ID, user.ID, "", -1)
+
+	bot.sendSystemMessage(user.ID, messages.CooldownRemovedMessage)
+	bot.sendSystemMessageReply(ctx.User.ID, fmt.Sprintf(messages.UserUncooldownedMessage, args[0]), ctx.Message.MessageID)
+}
+
+// ModLogLimit is the number of moderation actions shown by /modlog.
+const ModLogLimit = 20
+
+func cmdModLog(bot *SecretSquirrel, ctx *BotContext) {
+	// must be an admin
+	if ctx.User == nil || !ctx.User.IsAdmin() {
+		return
+	}
+
+	var scopes []func(*gorm.DB) *gorm.DB
+	for _, arg := range strings.Fields(ctx.Message.CommandArguments()) {
+		if action, ok := database.ParseModActionType(arg); ok {
+			scopes = append(scopes, database.ModActionsByAction(action))
+			continue
+		}
+
+		user, err := bot.findUser(arg)
+		if err != nil {
+			bot.sendSystemMessageReply(ctx.User.ID, messages.NoUserError, ctx.Message.MessageID)
+			return
+		}
+		scopes = append(scopes, database.ModActionsByTarget(user.ID))
+	}
+
+	actions, err := database.FindModActions(bot.Db, ModLogLimit, scopes...)
+	if err != nil {
+		bot.logUser(ctx.User.ID).WithError(err).Error("failed to find moderation actions.")
+		return
+	}
+
+	if len(actions) == 0 {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NoModActionsMessage, ctx.Message.MessageID)
+		return
+	}
+
+	// users are only shown by their obfuscated id.
+	names := map[int64]string{database.ConsoleActorID: "console"}
+	name := func(id int64) string {
+		if n, ok := names[id]; ok {
+			return n
+		}
+		names[id] = "?"
+		if user, err := database.FindUser(bot.Db, database.ByID(id)); err == nil {
+			names[id] = user.GetObfuscatedID()
+		}
+		return names[id]
+	}
+
+	var entries []messages.ModLogEntry
+	for _, a := range actions {
+		entries = append(entries, messages.ModLogEntry{
+			Time:   a.CreatedAt,
+			Action: a.Action,
+			Actor:  name(a.ActorID),
+			Target: name(a.TargetID),
+			Reason: a.Reason,
+		})
+	}
+
+	text, err := messages.ModLog(entries)
+	if err != nil {
+		bot.sendSystemMessage(ctx.User.ID, err.Error())
+		return
+	}
+
+	bot.sendSystemMessageReply(ctx.User.ID, text, ctx.Message.MessageID)
+}
+
+// findUser looks up a user by the obfuscated id shown in /info, their telegram id or their username.
+func (bot *SecretSquirrel) findUser(s string) (*database.User, error) {
+	s = strings.TrimPrefix(s, "@")
+
+	// obfuscated ids rotate daily so they can only be resolved against the current users.
+	if len(s) == 4 {
+		for _, u := range bot.Users.all() {
+			if u.GetObfuscatedID() == s {
+				user := u
+				return &user, nil
+			}
+		}
+	}
+
+	return database.FindUser(bot.Db, database.ByUsernameOrID(s))
+}
+
+// recordModAction appends a moderation action to the moderation log.
+func (bot *SecretSquirrel) recordModAction(action database.ModActionType, actorID int64, targetID int64, reason string, msid int) {
+	entry := bot.Log.WithFields(logrus.Fields{
+		"action": action,
+		"actor":  logging.UserID(actorID),
+		"target": logging.UserID(targetID),
+	})
+
+	err := database.SaveModAction(bot.Db, database.NewModAction(action, actorID, targetID, strings.TrimSpace(reason), msid))
+	if err != nil {
+		entry.WithError(err).Error("failed to record moderation action.")
+		return
+	}
+	entry.Info("moderation action.")
+}
+
 func cmdVersion(bot *SecretSquirrel, ctx *BotContext) {
 	bot.sendSystemMessage(ctx.User.ID, fmt.Sprintf(messages.VersionMessage, BotVersion))
 }
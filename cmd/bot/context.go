@@ -25,15 +25,49 @@ const (
 	VenueContentType
 )
 
+func (t ContentType) String() string {
+	switch t {
+	case MessageContentType:
+		return "message"
+	case StickerContentType:
+		return "sticker"
+	case AnimationContentType:
+		return "animation"
+	case PhotoContentType:
+		return "photo"
+	case VideoContentType:
+		return "video"
+	case AudioContentType:
+		return "audio"
+	case VoiceContentType:
+		return "voice"
+	case DocumentContentType:
+		return "document"
+	case VideoNoteContentType:
+		return "video_note"
+	case ContactContentType:
+		return "contact"
+	case LocationContentType:
+		return "location"
+	case VenueContentType:
+		return "venue"
+	default:
+		return fmt.Sprintf("%d", t)
+	}
+}
+
 type BotContext struct {
 	User           *database.User
 	ContentType    ContentType
 	Update         *tgbotapi.Update
 	Message        *tgbotapi.Message
+	Callback       *tgbotapi.CallbackQuery
 	ReplyID        int
 	CacheMessageID int
 	Signed         bool
 	Tripcode       bool
+	Edited         bool
+	Official       string // staff tag of /modsay and /adminsay messages
 }
 
 func (ctx *BotContext) HasFile() bool {
@@ -48,6 +82,23 @@ func (ctx *BotContext) IsForward() bool {
 	return ctx.Message.ForwardFrom != nil || ctx.Message.ForwardFromChat != nil
 }
 
+func (ctx *BotContext) IsOfficial() bool {
+	return ctx.Official != ""
+}
+
+func (ctx *BotContext) IsEdit() bool {
+	return ctx.Edited
+}
+
+// HasCaption reports whether the message text is sent as a media caption.
+func (ctx *BotContext) HasCaption() bool {
+	switch ctx.ContentType {
+	case PhotoContentType, AnimationContentType, VideoContentType, AudioContentType, VoiceContentType, DocumentContentType:
+		return true
+	}
+	return false
+}
+
 func (ctx *BotContext) UserLeftOrKicked() bool {
 	//  update.MyChatMember is only present in updates on join/leave.
 	return ctx.Update.MyChatMember != nil && ctx.Update.MyChatMember.NewChatMember.Status == "kicked"
@@ -57,47 +108,62 @@ func (bot *SecretSquirrel) CreateContext(u tgbotapi.Update) *BotContext {
 	var err error
 	var user *database.User
 
-	if cacheUser, ok := (*bot.Users)[u.Message.From.ID]; ok {
-		user = &cacheUser
-	} else {
-		user, _ = database.FindUser(bot.Db, database.ByID(u.Message.From.ID))
+	from := u.SentFrom()
+	if from == nil && u.MyChatMember != nil {
+		from = &u.MyChatMember.From
+	}
+
+	if from != nil {
+		if cacheUser, ok := bot.Users.get(from.ID); ok {
+			user = &cacheUser
+		} else {
+			user, _ = database.FindUser(bot.Db, database.ByID(from.ID))
+		}
 	}
 
 	ctx := BotContext{
 		User:           user,
 		ContentType:    MessageContentType,
+		Update:         &u,
+		Callback:       u.CallbackQuery,
 		ReplyID:        -1,
 		CacheMessageID: -1,
 		Signed:         false,
 		Tripcode:       false,
+		Edited:         false,
+		Official:       "",
 	}
 
-	if u.Message != nil {
-		ctx.Update = &u
+	if u.EditedMessage != nil {
+		ctx.Message = u.EditedMessage
+		ctx.Edited = true
+	} else {
 		ctx.Message = u.Message
+	}
 
+	if ctx.Message != nil {
 		switch {
-		case ctx.Update.Message.Sticker != nil:
+		case ctx.Message.Sticker != nil:
 			ctx.ContentType = StickerContentType
-		case ctx.Update.Message.Animation != nil:
+		case ctx.Message.Animation != nil:
 			ctx.ContentType = AnimationContentType
-		case ctx.Update.Message.Photo != nil:
+		case ctx.Message.Photo != nil:
 			ctx.ContentType = PhotoContentType
-		case ctx.Update.Message.Video != nil:
+		case ctx.Message.Video != nil:
 			ctx.ContentType = VideoContentType
-		case ctx.Update.Message.Voice != nil:
+		case ctx.Message.Voice != nil:
 			ctx.ContentType = VoiceContentType
-		case ctx.Update.Message.VideoNote != nil:
+		case ctx.Message.VideoNote != nil:
 			ctx.ContentType = VideoContentType
-		case ctx.Update.Message.Audio != nil:
+		case ctx.Message.Audio != nil:
 			ctx.ContentType = AudioContentType
-		case ctx.Update.Message.Location != nil:
+		case ctx.Message.Location != nil:
 			ctx.ContentType = LocationContentType
-		case ctx.Update.Message.Venue != nil:
+		case ctx.Message.Venue != nil:
 			ctx.ContentType = VenueContentType
-		case ctx.Update.Message.Contact != nil:
+		case ctx.Message.Contact != nil:
 			ctx.ContentType = ContactContentType
-		case ctx.Update.Message.Document != nil:
+		case ctx.Message.Document != nil:
 			ctx.ContentType = DocumentContentType
 		default:
 			ctx.ContentType = MessageContentType
@@ -108,7 +174,7 @@ func (bot *SecretSquirrel) CreateContext(u tgbotapi.Update) *BotContext {
 		if ctx.IsReply() {
 			ctx.ReplyID, err = bot.Cache.lookupCacheMessageKey(ctx.Message.From.ID, ctx.Message.ReplyToMessage.MessageID)
 			if err != nil {
-				fmt.Println(err)
+				bot.logUser(ctx.Message.From.ID).WithError(err).Debug("reply is not in the message cache.")
 			}
 		}
 	}
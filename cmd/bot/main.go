@@ -2,11 +2,19 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
 	"secretsquirrel/config"
+	"secretsquirrel/logging"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// ShutdownTimeout is how long the workers get to relay queued messages on shutdown.
+const ShutdownTimeout = 30 * time.Second
+
 var cfg config.Config
 
 func init() {
@@ -14,15 +22,55 @@ func init() {
 }
 
 func main() {
-	bot := initBot()
+	var (
+		updates tgbotapi.UpdatesChannel
+		err     error
+	)
+
+	logger, err := logging.New(&cfg)
+	if err != nil {
+		log.Fatalf("Error setting up logging, %s", err)
+	}
 
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = 60
-	updates := bot.Api.GetUpdatesChan(updateConfig)
+	bot := initBot(logger)
 
-	log.Printf("Authorized on account %s", bot.Api.Self.UserName)
+	if cfg.Metrics.Listen != "" {
+		bot.serveMetrics()
+	}
 
-	for u := range updates {
-		bot.handleUpdate(u)
+	if cfg.Bot.Webhook.URL != "" {
+		updates, err = bot.listenWebhook()
+	} else {
+		updates, err = bot.listenPolling()
+	}
+	if err != nil {
+		logger.WithError(err).Panic("failed to start receiving updates.")
 	}
+
+	self, err := bot.Api.GetMe()
+	if err != nil {
+		logger.WithError(err).Panic("failed to get the bot account.")
+	}
+	logger.WithField("account", self.UserName).Info("authorized.")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+loop:
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				break loop
+			}
+			bot.handleUpdate(u)
+		case s := <-signals:
+			logger.WithField("signal", s).Info("shutting down.")
+			break loop
+		}
+	}
+
+	bot.stopReceiving()
+	bot.drainUpdates(updates)
+	bot.Shutdown(ShutdownTimeout)
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"secretsquirrel/database"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	relayedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "secretsquirrel_relayed_messages_total",
+		Help: "Messages relayed to the chat, by content type.",
+	}, []string{"content_type"})
+
+	sendLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "secretsquirrel_send_duration_seconds",
+		Help:    "Time taken by the workers to send a message to a recipient.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	sendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "secretsquirrel_send_errors_total",
+		Help: "Failed sends, by telegram error code.",
+	}, []string{"code"})
+
+	spamRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "secretsquirrel_spam_rejections_total",
+		Help: "Messages rejected by the spam filter.",
+	})
+
+	moderationActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "secretsquirrel_moderation_actions_total",
+		Help: "Moderation actions taken, by command.",
+	}, []string{"command"})
+)
+
+// registerMetrics registers the metrics that are read from the bot's state when scraped.
+func (bot *SecretSquirrel) registerMetrics() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "secretsquirrel_queue_depth",
+		Help: "Jobs waiting to be delivered, including the ones waiting for a retry.",
+	}, func() float64 {
+		count, err := database.CountOutboundJobs(bot.Db)
+		if err != nil {
+			bot.Log.WithError(err).Error("failed to count outbound jobs.")
+		}
+		return float64(count)
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "secretsquirrel_cached_messages",
+		Help: "Messages in the message cache.",
+	}, func() float64 {
+		return float64(bot.Cache.size())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "secretsquirrel_active_users",
+		Help: "Users currently in the chat.",
+	}, func() float64 {
+		return float64(bot.Users.size())
+	})
+}
+
+// serveMetrics exposes the metrics over http on the configured address.
+func (bot *SecretSquirrel) serveMetrics() {
+	bot.registerMetrics()
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Metrics.Path, promhttp.Handler())
+	bot.metrics = &http.Server{Addr: cfg.Metrics.Listen, Handler: mux}
+
+	go func() {
+		if err := bot.metrics.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			bot.Log.WithError(err).Error("metrics server failed.")
+		}
+	}()
+}
+
+func errorCodeLabel(code int) string {
+	if code == 0 {
+		return "network"
+	}
+	return strconv.Itoa(code)
+}
@@ -17,7 +17,6 @@ type BotMessage struct {
 
 func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid int) *BotMessage {
 	var (
-		builder  strings.Builder
 		err      error
 		baseChat tgbotapi.BaseChat = tgbotapi.BaseChat{ChatID: user.ID}
 		msg      BotMessage        = BotMessage{User: user, MessageID: msid}
@@ -36,33 +35,19 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 	if ctx.ReplyID != -1 {
 		msg.ReplyID, err = bot.Cache.lookupCacheMessageValue(msg.User.ID, ctx.ReplyID)
 		if err != nil {
-			fmt.Println(err)
+			bot.logUser(msg.User.ID).WithError(err).Debug("reply is not in the message cache.")
 		}
 		baseChat.ReplyToMessageID = msg.ReplyID
 	}
 
-	// Build the text for the message.
-	if ctx.Tripcode || ctx.User.ToggleTripcode {
-		trip := genTripcode(ctx.User.Tripcode)
-		builder.WriteString(fmt.Sprintf("<b>%s</b> <code>%s</code>\n", trip[0], trip[1]))
-	}
-
-	if ctx.Message.IsCommand() {
-		builder.WriteString(ctx.Message.CommandArguments())
-	} else {
-		builder.WriteString(ctx.Message.Text)
-	}
-
-	if ctx.Signed {
-		fmt.Fprintf(&builder, " <a href=\"tg://user?id=%d\">~~%s</a>", msg.User.ID, msg.User.GetFormattedUsername())
-	}
+	text := formatMessageText(ctx)
 
 	// create appropriate message config based on content type
 	switch ctx.ContentType {
 	case MessageContentType:
 		msg.Config = tgbotapi.MessageConfig{
 			BaseChat:              baseChat,
-			Text:                  builder.String(),
+			Text:                  text,
 			ParseMode:             "HTML",
 			DisableWebPagePreview: false,
 		}
@@ -73,7 +58,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Photo[len(ctx.Message.Photo)-1].FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 	case AnimationContentType:
 		msg.Config = tgbotapi.AnimationConfig{
@@ -82,7 +67,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Animation.FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 	case VideoContentType:
 		msg.Config = tgbotapi.VideoConfig{
@@ -91,7 +76,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Video.FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 	case AudioContentType:
 		msg.Config = tgbotapi.AudioConfig{
@@ -100,7 +85,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Audio.FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 	case VoiceContentType:
 		msg.Config = tgbotapi.VoiceConfig{
@@ -109,7 +94,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Voice.FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 
 	case DocumentContentType:
@@ -119,7 +104,7 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 				File:     tgbotapi.FileID(ctx.Message.Document.FileID),
 			},
 			ParseMode: "HTML",
-			Caption:   builder.String(),
+			Caption:   text,
 		}
 	// captionless types
 	case StickerContentType:
@@ -163,3 +148,50 @@ func (bot *SecretSquirrel) NewMessage(ctx *BotContext, user *database.User, msid
 
 	return &msg
 }
+
+// formatMessageText builds the relayed text or caption of a message, including tripcode and sign decorations.
+func formatMessageText(ctx *BotContext) string {
+	var builder strings.Builder
+
+	if ctx.Tripcode {
+		trip := genTripcode(ctx.User.Tripcode)
+		builder.WriteString(fmt.Sprintf("<b>%s</b> <code>%s</code>\n", trip[0], trip[1]))
+	}
+
+	switch {
+	case ctx.Message.IsCommand():
+		builder.WriteString(ctx.Message.CommandArguments())
+	case ctx.HasCaption():
+		builder.WriteString(ctx.Message.Caption)
+	default:
+		builder.WriteString(ctx.Message.Text)
+	}
+
+	if ctx.Signed {
+		fmt.Fprintf(&builder, " <a href=\"tg://user?id=%d\">~~%s</a>", ctx.User.ID, ctx.User.GetFormattedUsername())
+	}
+
+	if ctx.IsOfficial() {
+		fmt.Fprintf(&builder, " ~<b>%s</b>", ctx.Official)
+	}
+
+	return builder.String()
+}
+
+// NewEdit creates the edit for a user's copy of a relayed message. Returns nil if the content type can't be edited.
+func (bot *SecretSquirrel) NewEdit(ctx *BotContext, user *database.User, mappedID int) tgbotapi.Chattable {
+	text := formatMessageText(ctx)
+
+	switch {
+	case ctx.ContentType == MessageContentType:
+		edit := tgbotapi.NewEditMessageText(user.ID, mappedID, text)
+		edit.ParseMode = "HTML"
+		return edit
+	case ctx.HasCaption():
+		edit := tgbotapi.NewEditMessageCaption(user.ID, mappedID, text)
+		edit.ParseMode = "HTML"
+		return edit
+	}
+
+	return nil
+}
@@ -29,6 +29,25 @@ func (q *PriorityQueue) Add(id int64) {
 	q.itemSet[id] = struct{}{}
 }
 
+func (q *PriorityQueue) Remove(id int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.itemSet[id]; !ok {
+		return
+	}
+
+	for i, item := range q.items {
+		if item == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	delete(q.itemSet, id)
+}
+
+// Update moves a user to the front of the queue. Since it's called whenever a user is active,
+// the queue stays ordered by each user's LastActive.
 func (q *PriorityQueue) Update(id int64) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -46,15 +65,17 @@ func (q *PriorityQueue) Update(id int64) {
 		}
 	}
 
-	target := 0
-	val := q.items[pos]
-	q.items = append(q.items[:pos], q.items[pos+1:]...)
-	newSlice := make([]int64, target+1)
-	copy(newSlice, q.items[:target])
-	newSlice[target] = val
-	q.items = append(newSlice, q.items[target:]...)
+	// shift everything before the element back by one and put it in front.
+	copy(q.items[1:pos+1], q.items[:pos])
+	q.items[0] = id
 }
 
+// Get returns a snapshot of the queue, most recently active users first.
 func (q *PriorityQueue) Get() []int64 {
-	return q.items
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+
+	items := make([]int64, len(q.items))
+	copy(items, q.items)
+	return items
 }
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"secretsquirrel/database"
+	"secretsquirrel/logging"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	QueueSize        = 1000
+	MaxSendAttempts  = 5
+	RetryBackoffBase = 5 * time.Second
+	RetryBackoffMax  = 10 * time.Minute
+)
+
+// Queue is the outbound delivery queue. Every job is stored in the database before it's handed to the
+// workers, so jobs survive restarts and failed sends can be retried without holding up other recipients.
+type Queue struct {
+	ch     chan *QueueJob
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+	// stop is closed when the workers must give up on the jobs they haven't started yet.
+	stop     chan struct{}
+	stopOnce sync.Once
+	db       *gorm.DB
+	log      *logrus.Logger
+
+	stateMu sync.Mutex
+	// ids of jobs that are currently handed to the workers.
+	pending map[uint]struct{}
+	// recipients that must not be sent anything until the given time.
+	blocked map[int64]time.Time
+}
+
+func NewQueue(db *gorm.DB, log *logrus.Logger) *Queue {
+	return &Queue{
+		ch:      make(chan *QueueJob, QueueSize),
+		mu:      sync.Mutex{},
+		stop:    make(chan struct{}),
+		db:      db,
+		log:     log,
+		stateMu: sync.Mutex{},
+		pending: make(map[uint]struct{}),
+		blocked: make(map[int64]time.Time),
+	}
+}
+
+// jobItem is the stored form of a single relayed message.
+type jobItem struct {
+	Message        *tgbotapi.Message
+	ContentType    ContentType
+	ReplyID        int
+	CacheMessageID int
+	Signed         bool
+	Tripcode       bool
+	Official       string
+}
+
+type jobPayload struct {
+	Album bool
+	Edit  bool
+	Items []jobItem
+}
+
+func newJobItem(ctx *BotContext) jobItem {
+	return jobItem{
+		Message:        ctx.Message,
+		ContentType:    ctx.ContentType,
+		ReplyID:        ctx.ReplyID,
+		CacheMessageID: ctx.CacheMessageID,
+		Signed:         ctx.Signed,
+		Tripcode:       ctx.Tripcode,
+		Official:       ctx.Official,
+	}
+}
+
+func encodeJob(j *QueueJob) (string, error) {
+	payload := jobPayload{Edit: j.Edit}
+
+	if j.Album != nil {
+		payload.Album = true
+		for _, ctx := range j.Album {
+			payload.Items = append(payload.Items, newJobItem(ctx))
+		}
+	} else {
+		payload.Items = []jobItem{newJobItem(j.Context)}
+	}
+
+	b, err := json.Marshal(payload)
+	return string(b), err
+}
+
+// decodeJob rebuilds a QueueJob from its database record.
+func (bot *SecretSquirrel) decodeJob(record *database.OutboundJob) (*QueueJob, error) {
+	var payload jobPayload
+
+	if err := json.Unmarshal([]byte(record.Payload), &payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Items) == 0 {
+		return nil, errors.New("decodeJob: empty payload")
+	}
+
+	recipient, ok := bot.Users.get(record.RecipientID)
+	if !ok {
+		return nil, errors.New("decodeJob: recipient is not in the chat")
+	}
+
+	sender, err := database.FindUser(bot.Db, database.ByID(payload.Items[0].Message.From.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	var contexts []*BotContext
+	for _, item := range payload.Items {
+		contexts = append(contexts, &BotContext{
+			User:           sender,
+			ContentType:    item.ContentType,
+			Message:        item.Message,
+			ReplyID:        item.ReplyID,
+			CacheMessageID: item.CacheMessageID,
+			Signed:         item.Signed,
+			Tripcode:       item.Tripcode,
+			Official:       item.Official,
+		})
+	}
+
+	j := &QueueJob{Bot: bot, User: &recipient, Context: contexts[0], Edit: payload.Edit, Record: record}
+	if payload.Album {
+		j.Album = contexts
+	}
+	return j, nil
+}
+
+// push stores jobs and hands them to the workers. It never blocks: if the workers are busy,
+// the jobs are picked up from the database by retryTask instead.
+func (q *Queue) push(jobs []*QueueJob) {
+	var (
+		records  []*database.OutboundJob
+		payloads = map[*BotContext]string{}
+	)
+
+	for _, j := range jobs {
+		// all recipients of a message share the same payload.
+		payload, ok := payloads[j.Context]
+		if !ok {
+			var err error
+			if payload, err = encodeJob(j); err != nil {
+				q.log.WithError(err).Error("failed to encode job.")
+				continue
+			}
+			payloads[j.Context] = payload
+		}
+
+		j.Record = &database.OutboundJob{
+			RecipientID: j.User.ID,
+			Payload:     payload,
+			NextAttempt: time.Now(),
+		}
+		records = append(records, j.Record)
+	}
+
+	if err := database.SaveOutboundJobs(q.db, records); err != nil {
+		q.log.WithError(err).WithField("jobs", len(records)).Error("failed to save jobs.")
+		return
+	}
+
+	for _, j := range jobs {
+		if j.Record != nil {
+			q.dispatch(j)
+		}
+	}
+}
+
+// dispatch hands a stored job to the workers unless it's already with them or the queue is full.
+func (q *Queue) dispatch(j *QueueJob) {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	if _, ok := q.pending[j.Record.ID]; ok {
+		return
+	}
+
+	q.pending[j.Record.ID] = struct{}{}
+	select {
+	case q.ch <- j:
+	default:
+		delete(q.pending, j.Record.ID)
+	}
+}
+
+// retryTask dispatches the stored jobs that are due, e.g. retries and jobs left over from a restart.
+func (bot *SecretSquirrel) retryTask() {
+	q := bot.Queue
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	records, err := database.FindDueOutboundJobs(q.db, QueueSize)
+	if err != nil {
+		q.log.WithError(err).Error("failed to find due jobs.")
+		return
+	}
+
+	for i := range records {
+		record := &records[i]
+
+		q.stateMu.Lock()
+		_, pending := q.pending[record.ID]
+		q.stateMu.Unlock()
+		if pending {
+			continue
+		}
+
+		j, err := bot.decodeJob(record)
+		if err != nil {
+			// nothing to deliver anymore, e.g. the recipient left.
+			if err := database.DeleteOutboundJob(q.db, record.ID); err != nil {
+				q.logJob(record).WithError(err).Error("failed to delete job.")
+			}
+			continue
+		}
+
+		q.dispatch(j)
+	}
+}
+
+// blockedUntil returns until when a recipient must not be sent anything.
+func (q *Queue) blockedUntil(uid int64) (time.Time, bool) {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	until, ok := q.blocked[uid]
+	if ok && time.Now().After(until) {
+		delete(q.blocked, uid)
+		return until, false
+	}
+	return until, ok
+}
+
+func (q *Queue) block(uid int64, until time.Time) {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	if until.After(q.blocked[uid]) {
+		q.blocked[uid] = until
+	}
+}
+
+// postpone reschedules a job without counting it as an attempt.
+func (q *Queue) postpone(j *QueueJob, until time.Time) {
+	j.Record.NextAttempt = until
+	if err := database.RescheduleOutboundJob(q.db, j.Record); err != nil {
+		q.logJob(j.Record).WithError(err).Error("failed to reschedule job.")
+	}
+	q.release(j)
+}
+
+// complete records the outcome of a send.
+func (q *Queue) complete(j *QueueJob, err error) {
+	defer q.release(j)
+
+	if err == nil {
+		if err := database.DeleteOutboundJob(q.db, j.Record.ID); err != nil {
+			q.logJob(j.Record).WithError(err).Error("failed to delete job.")
+		}
+		return
+	}
+
+	j.Record.Attempts++
+	j.Record.LastError = err.Error()
+
+	retryAfter, permanent := j.Bot.classifySendError(j, err)
+	if permanent || j.Record.Attempts >= MaxSendAttempts {
+		if err := database.DeadLetterOutboundJob(q.db, j.Record); err != nil {
+			q.logJob(j.Record).WithError(err).Error("failed to dead-letter job.")
+			return
+		}
+		q.logJob(j.Record).Warn("job failed permanently, moved to dead letters.")
+		return
+	}
+
+	if retryAfter == 0 {
+		retryAfter = backoff(j.Record.Attempts)
+	}
+	j.Record.NextAttempt = time.Now().Add(retryAfter)
+	q.block(j.User.ID, j.Record.NextAttempt)
+
+	if err := database.RescheduleOutboundJob(q.db, j.Record); err != nil {
+		q.logJob(j.Record).WithError(err).Error("failed to reschedule job.")
+	}
+}
+
+func (q *Queue) release(j *QueueJob) {
+	q.stateMu.Lock()
+	defer q.stateMu.Unlock()
+
+	delete(q.pending, j.Record.ID)
+}
+
+// close stops accepting new jobs. Workers exit once they have relayed everything already handed to them,
+// anything else stays in the database until the next start.
+func (q *Queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// cancel makes the workers skip the jobs they haven't started yet. The jobs stay in the database
+// until the next start.
+func (q *Queue) cancel() {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+	})
+}
+
+func (q *Queue) cancelled() bool {
+	select {
+	case <-q.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// logJob returns a log entry for things concerning a stored job.
+func (q *Queue) logJob(record *database.OutboundJob) *logrus.Entry {
+	return q.log.WithFields(logrus.Fields{
+		"job":      record.ID,
+		"user":     logging.UserID(record.RecipientID),
+		"attempts": record.Attempts,
+	})
+}
+
+// backoff returns the exponential backoff for the given number of failed attempts.
+func backoff(attempts int) time.Duration {
+	d := time.Duration(float64(RetryBackoffBase) * math.Pow(2, float64(attempts-1)))
+	if d > RetryBackoffMax || d <= 0 {
+		return RetryBackoffMax
+	}
+	return d
+}
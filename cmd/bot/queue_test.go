@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"secretsquirrel/database"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestJob stores a job for the given recipient, as push would.
+func newTestJob(t *testing.T, bot *SecretSquirrel, recipient int64) *QueueJob {
+	t.Helper()
+
+	j := &QueueJob{
+		Bot:     bot,
+		User:    &database.User{ID: recipient},
+		Context: &BotContext{Message: &tgbotapi.Message{From: &tgbotapi.User{ID: 10}, Text: "hi"}},
+		Record:  &database.OutboundJob{RecipientID: recipient, Payload: "{}", NextAttempt: time.Now()},
+	}
+	if err := database.SaveOutboundJobs(bot.Db, []*database.OutboundJob{j.Record}); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func countDeadLetters(t *testing.T, bot *SecretSquirrel) int64 {
+	t.Helper()
+
+	var n int64
+	if err := bot.Db.Model(&database.DeadLetter{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestQueueRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	bot, _ := newTestBot(t)
+	j := newTestJob(t, bot, 20)
+
+	for attempt := 1; attempt < MaxSendAttempts; attempt++ {
+		before := time.Now()
+		bot.Queue.complete(j, errors.New("connection reset"))
+
+		var record database.OutboundJob
+		if err := bot.Db.First(&record, j.Record.ID).Error; err != nil {
+			t.Fatalf("attempt %d: job is gone: %v", attempt, err)
+		}
+		if record.Attempts != attempt {
+			t.Errorf("attempt %d: attempts = %d", attempt, record.Attempts)
+		}
+		if wait := record.NextAttempt.Sub(before); wait < backoff(attempt) || wait > backoff(attempt)+time.Second {
+			t.Errorf("attempt %d: retried after %s, want %s", attempt, wait, backoff(attempt))
+		}
+		if _, blocked := bot.Queue.blockedUntil(20); !blocked {
+			t.Errorf("attempt %d: recipient isn't blocked until the retry", attempt)
+		}
+	}
+	if n := countDeadLetters(t, bot); n != 0 {
+		t.Fatalf("dead-lettered before the last attempt")
+	}
+
+	bot.Queue.complete(j, errors.New("connection reset"))
+
+	if n, _ := database.CountOutboundJobs(bot.Db); n != 0 {
+		t.Errorf("%d jobs left after the last attempt", n)
+	}
+	var dl database.DeadLetter
+	if err := bot.Db.First(&dl).Error; err != nil {
+		t.Fatalf("job wasn't dead-lettered: %v", err)
+	}
+	if dl.Attempts != MaxSendAttempts || dl.RecipientID != 20 {
+		t.Errorf("dead letter = %+v", dl)
+	}
+}
+
+func TestQueueSendErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		dead      bool
+		wantDelay time.Duration
+	}{
+		{"bad request", &tgbotapi.Error{Code: 400, Message: "Bad Request"}, true, 0},
+		{"chat not found", &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}, true, 0},
+		{"rate limited", &tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}}, false, 30 * time.Second},
+		{"server error", &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, false, backoff(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot, _ := newTestBot(t)
+			j := newTestJob(t, bot, 20)
+
+			before := time.Now()
+			bot.Queue.complete(j, tt.err)
+
+			if n := countDeadLetters(t, bot); (n == 1) != tt.dead {
+				t.Fatalf("dead letters = %d, want dead-lettered: %v", n, tt.dead)
+			}
+			if tt.dead {
+				return
+			}
+
+			var record database.OutboundJob
+			if err := bot.Db.First(&record, j.Record.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if wait := record.NextAttempt.Sub(before); wait < tt.wantDelay || wait > tt.wantDelay+time.Second {
+				t.Errorf("retried after %s, want %s", wait, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestQueueDeliversAndDeletesJob(t *testing.T) {
+	bot, api := newTestBot(t)
+	j := newTestJob(t, bot, 20)
+
+	if err := sendMessage(j); err != nil {
+		t.Fatal(err)
+	}
+	bot.Queue.complete(j, nil)
+
+	if sent := api.SentTo(20); len(sent) != 1 {
+		t.Errorf("sent %d messages to the recipient, want 1", len(sent))
+	}
+	if n, _ := database.CountOutboundJobs(bot.Db); n != 0 {
+		t.Errorf("%d jobs left after delivery", n)
+	}
+}
+
+func TestQueueChatNotFoundLeavesUser(t *testing.T) {
+	bot, _ := newTestBot(t)
+	bot.Users.set(database.User{ID: 20})
+	j := newTestJob(t, bot, 20)
+
+	bot.Queue.complete(j, &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"})
+
+	if bot.Users.has(20) {
+		t.Error("recipient whose chat doesn't exist is still relayed messages")
+	}
+}
+
+func TestLeaveUserForgetsChatLimiter(t *testing.T) {
+	bot, _ := newTestBot(t)
+	bot.Limiter = NewRateLimiter(0, 1)
+	bot.Limiter.take(20)
+
+	bot.leaveUser(&database.User{ID: 20})
+
+	if _, ok := bot.Limiter.chats[20]; ok {
+		t.Error("limiter of a user who left is still kept")
+	}
+}
+
+func TestEditNotModifiedIsDelivered(t *testing.T) {
+	bot, api := newTestBot(t)
+	j := newTestJob(t, bot, 20)
+	j.Edit = true
+	j.Context.CacheMessageID = bot.Cache.newMessage(j.Context)
+	bot.Cache.saveMapping(20, j.Context.CacheMessageID, 5)
+
+	api.FailFor(20, &tgbotapi.Error{Code: 400, Message: "Bad Request: message is not modified"})
+	if err := sendEdit(j); err != nil {
+		t.Errorf("sendEdit() = %v, want nil", err)
+	}
+
+	// other errors still fail the job.
+	api.FailFor(20, &tgbotapi.Error{Code: 400, Message: "Bad Request: message to edit not found"})
+	if err := sendEdit(j); err == nil {
+		t.Error("sendEdit() = nil, want an error")
+	}
+}
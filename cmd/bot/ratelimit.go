@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+
+	"go.uber.org/ratelimit"
+)
+
+// RateLimiter paces requests to telegram to stay within both its global and its per chat limits.
+type RateLimiter struct {
+	mu       sync.Mutex
+	global   ratelimit.Limiter
+	chats    map[int64]ratelimit.Limiter
+	chatRate int
+}
+
+func NewRateLimiter(globalRate int, chatRate int) *RateLimiter {
+	rl := &RateLimiter{
+		mu:       sync.Mutex{},
+		global:   ratelimit.NewUnlimited(),
+		chats:    make(map[int64]ratelimit.Limiter),
+		chatRate: chatRate,
+	}
+
+	if globalRate > 0 {
+		rl.global = ratelimit.New(globalRate)
+	}
+
+	return rl
+}
+
+// take blocks until a request to the given chat may be sent.
+func (rl *RateLimiter) take(chatID int64) {
+	rl.chat(chatID).Take()
+	rl.global.Take()
+}
+
+func (rl *RateLimiter) chat(chatID int64) ratelimit.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.chatRate <= 0 {
+		return ratelimit.NewUnlimited()
+	}
+
+	l, ok := rl.chats[chatID]
+	if !ok {
+		l = ratelimit.New(rl.chatRate, ratelimit.WithoutSlack)
+		rl.chats[chatID] = l
+	}
+	return l
+}
+
+// forget drops the limiter of a chat that won't be sent to anymore.
+func (rl *RateLimiter) forget(chatID int64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delete(rl.chats, chatID)
+}
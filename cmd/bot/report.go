@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"secretsquirrel/database"
+	"secretsquirrel/messages"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func cmdReport(bot *SecretSquirrel, ctx *BotContext) {
+	if ctx.User == nil || ctx.User.Left.Valid {
+		bot.sendSystemMessage(ctx.Message.From.ID, messages.UserNotInChatMessage)
+		return
+	}
+
+	// reply required
+	if !ctx.IsReply() {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NoReplyError, ctx.Message.MessageID)
+		return
+	}
+
+	reason := strings.TrimSpace(ctx.Message.CommandArguments())
+	if reason == "" {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NoReportReasonError, ctx.Message.MessageID)
+		return
+	}
+
+	cm, err := bot.Cache.getMessage(ctx.ReplyID)
+	if err != nil {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.NotInCacheError, ctx.Message.MessageID)
+		return
+	}
+
+	if cm.userID == ctx.User.ID {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.ReportOwnMessageError, ctx.Message.MessageID)
+		return
+	}
+
+	// every message is only reviewed once.
+	if cm.reported {
+		bot.sendSystemMessageReply(ctx.User.ID, messages.AlreadyReportedError, ctx.Message.MessageID)
+		return
+	}
+
+	report := database.Report{
+		CacheMessageID: ctx.ReplyID,
+		ReporterID:     ctx.User.ID,
+		TargetID:       cm.userID,
+		Reason:         reason,
+	}
+	if err := database.SaveReport(bot.Db, &report); err != nil {
+		bot.logUser(ctx.User.ID).WithError(err).Error("failed to save report.")
+		return
+	}
+	bot.Cache.setReported(ctx.ReplyID)
+
+	bot.sendReportCards(&report)
+	bot.sendSystemMessageReply(ctx.User.ID, messages.ReportSentMessage, ctx.Message.MessageID)
+}
+
+// sendReportCards sends a report to every moderator, as a reply to their copy of the reported message.
+func (bot *SecretSquirrel) sendReportCards(report *database.Report) {
+	text := bot.reportCardText(report)
+	keyboard, err := reportKeyboard(report)
+	if err != nil {
+		bot.Log.WithError(err).Error("failed to create report keyboard.")
+		return
+	}
+
+	for _, user := range bot.Users.snapshot(bot.UserQueue.Get()) {
+		if !user.IsPrivileged() || user.ID == report.TargetID {
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(user.ID, text)
+		msg.ParseMode = "HTML"
+		msg.ReplyMarkup = keyboard
+		if replyID, err := bot.Cache.lookupCacheMessageValue(user.ID, report.CacheMessageID); err == nil {
+			msg.ReplyToMessageID = replyID
+		}
+
+		bot.Limiter.take(user.ID)
+		if _, err := bot.Api.Send(msg); err != nil {
+			bot.logUser(user.ID).WithError(err).Warn("failed to send report.")
+		}
+	}
+}
+
+func (bot *SecretSquirrel) reportCardText(report *database.Report) string {
+	target := "?"
+	if user, err := database.FindUser(bot.Db, database.ByID(report.TargetID)); err == nil {
+		target = user.GetObfuscatedID()
+	}
+
+	return fmt.Sprintf(messages.ReportCardMessage, report.ID, target, html.EscapeString(report.Reason))
+}
+
+func reportKeyboard(report *database.Report) (tgbotapi.InlineKeyboardMarkup, error) {
+	actions := [][]string{{"Warn", "warn"}, {"Delete", "delete"}}
+	if cfg.Limits.AllowRemoveCommand {
+		actions = append(actions, []string{"Remove", "remove"})
+	}
+	actions = append(actions, []string{"Dismiss", "dismiss"})
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, a := range actions {
+		button, err := callbackButton(a[0], "report", a[1], strconv.FormatUint(uint64(report.ID), 10))
+		if err != nil {
+			return tgbotapi.InlineKeyboardMarkup{}, err
+		}
+		row = append(row, button)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(row), nil
+}
+
+// cbReport takes the moderation action chosen on a report card.
+func cbReport(bot *SecretSquirrel, ctx *BotContext, args []string) {
+	if len(args) != 2 {
+		bot.answerCallback(ctx, "")
+		return
+	}
+	action := args[0]
+
+	reportID, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		bot.answerCallback(ctx, "")
+		return
+	}
+
+	report, err := database.FindReport(bot.Db, uint(reportID))
+	if err != nil {
+		bot.answerCallback(ctx, messages.NotInCacheError)
+		return
+	}
+
+	if report.IsResolved() {
+		bot.answerCallback(ctx, messages.ReportResolvedError)
+		return
+	}
+
+	// a message warned through another report or /warn is still resolved, the moderator is told why
+	// no new warning was given.
+	notice := messages.ReportHandledMessage
+
+	switch action {
+	case "warn":
+		err = bot.warnMessage(ctx.User, report.CacheMessageID, report.Reason, false)
+		if errors.Is(err, errAlreadyWarned) {
+			notice, err = messages.AlreadyWarnedError, nil
+		}
+	case "delete":
+		err = bot.warnMessage(ctx.User, report.CacheMessageID, report.Reason, true)
+		if errors.Is(err, errAlreadyWarned) {
+			// the copies still have to go, without warning the author twice.
+			moderationActions.WithLabelValues("remove").Inc()
+			bot.recordModAction(database.ActionRemove, ctx.User.ID, report.TargetID, report.Reason, report.CacheMessageID)
+			notice, err = messages.ReportDeletedOnlyMessage, nil
+		}
+		if err == nil {
+			bot.deleteCopies(report.CacheMessageID)
+		}
+	case "remove":
+		if !cfg.Limits.AllowRemoveCommand {
+			bot.answerCallback(ctx, messages.CommandDisabledError)
+			return
+		}
+		err = bot.removeMessage(ctx.User, report.CacheMessageID, report.Reason)
+	case "dismiss":
+	default:
+		bot.answerCallback(ctx, "")
+		return
+	}
+	if err != nil {
+		bot.answerCallback(ctx, err.Error())
+		return
+	}
+
+	ok, err := database.ResolveReport(bot.Db, report, ctx.User.ID, action)
+	if err != nil {
+		bot.logUser(ctx.User.ID).WithError(err).Error("failed to resolve report.")
+	}
+	if !ok {
+		bot.answerCallback(ctx, messages.ReportResolvedError)
+		return
+	}
+
+	bot.answerCallback(ctx, notice)
+
+	// drop the buttons from the card that was used.
+	bot.editCallbackMessage(ctx, bot.reportCardText(report)+fmt.Sprintf(messages.ReportResolvedMessage, action), nil)
+}
@@ -25,9 +25,11 @@ func (k *Scorekeeper) increaseSpamScore(uid userID, n float32) bool {
 	}
 
 	if score > float32(cfg.Spam.SpamLimit) {
+		spamRejections.Inc()
 		return false
 	} else if score+n > float32(cfg.Spam.SpamLimit) {
 		k.scores[uid] = float32(cfg.Spam.SpamLimitHit)
+		spamRejections.Inc()
 		return false
 	}
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// listenWebhook registers the webhook with telegram and starts serving updates on the configured address.
+func (bot *SecretSquirrel) listenWebhook() (tgbotapi.UpdatesChannel, error) {
+	wh := cfg.Bot.Webhook
+
+	params := make(tgbotapi.Params)
+	params.AddNonEmpty("url", wh.URL)
+	params.AddNonEmpty("secret_token", wh.SecretToken)
+
+	var err error
+	if wh.CertFile != "" {
+		// telegram only trusts a self-signed certificate if it's uploaded with the webhook.
+		files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(wh.CertFile)}}
+		_, err = bot.Api.UploadFiles("setWebhook", params, files)
+	} else {
+		_, err = bot.Api.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan tgbotapi.Update, 100)
+	done := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wh.Path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if wh.SecretToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(wh.SecretToken)) != 1 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var u tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// telegram sends the update again later if it isn't accepted.
+		select {
+		case ch <- u:
+		case <-done:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+
+	server := &http.Server{Addr: wh.Listen, Handler: mux}
+	server.RegisterOnShutdown(func() { close(done) })
+	bot.webhook = server
+
+	go func() {
+		var err error
+		if wh.CertFile != "" {
+			err = server.ListenAndServeTLS(wh.CertFile, wh.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			bot.Log.WithError(err).Error("webhook server failed.")
+		}
+	}()
+
+	return ch, nil
+}
+
+// listenPolling removes any previously set webhook and starts long polling for updates.
+func (bot *SecretSquirrel) listenPolling() (tgbotapi.UpdatesChannel, error) {
+	if _, err := bot.Api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, err
+	}
+
+	updateConfig := tgbotapi.NewUpdate(0)
+	updateConfig.Timeout = 60
+	return bot.Api.GetUpdatesChan(updateConfig), nil
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"math"
 	"secretsquirrel/database"
+	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -13,37 +12,124 @@ type QueueJob struct {
 	Bot     *SecretSquirrel
 	User    *database.User
 	Context *BotContext
+	Album   []*BotContext
+	// Edit relays an edit of Context to the copy the user received.
+	Edit   bool
+	Record *database.OutboundJob
 }
 
-const (
-	MaxWorkerPool     = 2
-	MaxCallsPerSecond = 25
-)
-
 func worker(id int, ch <-chan *QueueJob) {
-	minTimeBetweenRuns := time.Duration(math.Ceil(1e9 / (MaxCallsPerSecond / float64(MaxWorkerPool))))
-
 	for j := range ch {
-		lastRun := time.Now()
-		timeUntilNextRun := -(time.Since(lastRun) - minTimeBetweenRuns)
-		if timeUntilNextRun > 0 {
-			time.Sleep(timeUntilNextRun)
+		// the bot is shutting down and ran out of time, the job is relayed after the next start.
+		if j.Bot.Queue.cancelled() {
+			j.Bot.Queue.release(j)
+			continue
+		}
+
+		// the recipient left since the job was queued, there's nothing to deliver anymore.
+		if !j.Bot.Users.has(j.User.ID) {
+			j.Bot.Queue.complete(j, nil)
+			continue
 		}
 
-		msg := j.Bot.NewMessage(j.Context, j.User, j.Context.CacheMessageID)
-		for {
-			s, err := j.Bot.Api.Send(msg.Config)
-			if err != nil {
-				fmt.Println(err)
-				// try again if rate-limited
-				if err.(*tgbotapi.Error).Code == 429 {
-					time.Sleep(time.Duration(err.(*tgbotapi.Error).ResponseParameters.RetryAfter) * time.Second)
-					continue
-				}
-				break
-			}
-			j.Bot.Cache.saveMapping(msg.User.ID, j.Context.CacheMessageID, int(s.MessageID))
-			break
+		// don't send anything to a recipient that is being backed off from.
+		if until, blocked := j.Bot.Queue.blockedUntil(j.User.ID); blocked {
+			j.Bot.Queue.postpone(j, until)
+			continue
 		}
+
+		j.Bot.Limiter.take(j.User.ID)
+
+		var err error
+		start := time.Now()
+		switch {
+		case j.Edit:
+			err = sendEdit(j)
+		case j.Album != nil:
+			err = sendAlbum(j)
+		default:
+			err = sendMessage(j)
+		}
+		sendLatency.Observe(time.Since(start).Seconds())
+
+		j.Bot.Queue.complete(j, err)
 	}
 }
+
+func sendMessage(j *QueueJob) error {
+	msg := j.Bot.NewMessage(j.Context, j.User, j.Context.CacheMessageID)
+
+	s, err := j.Bot.Api.Send(msg.Config)
+	if err != nil {
+		return err
+	}
+
+	j.Bot.Cache.saveMapping(msg.User.ID, j.Context.CacheMessageID, int(s.MessageID))
+	return nil
+}
+
+func sendEdit(j *QueueJob) error {
+	mappedID, err := j.Bot.Cache.lookupCacheMessageValue(j.User.ID, j.Context.CacheMessageID)
+	if err != nil {
+		// the copy expired from the cache or was deleted, there's nothing to edit anymore.
+		return nil
+	}
+
+	edit := j.Bot.NewEdit(j.Context, j.User, mappedID)
+	if edit == nil {
+		return nil
+	}
+
+	_, err = j.Bot.Api.Send(edit)
+	// the copy already looks like the edit, e.g. only formatting telegram drops was changed.
+	if apiErr, ok := err.(*tgbotapi.Error); ok && strings.Contains(apiErr.Message, "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+func sendAlbum(j *QueueJob) error {
+	config := j.Bot.NewMediaGroup(j.Album, j.User)
+
+	sent, err := j.Bot.Api.SendMediaGroup(config)
+	if err != nil {
+		return err
+	}
+
+	// telegram returns the album items in the order they were sent.
+	for i, s := range sent {
+		if i < len(j.Album) {
+			j.Bot.Cache.saveMapping(j.User.ID, j.Album[i].CacheMessageID, s.MessageID)
+		}
+	}
+	return nil
+}
+
+// classifySendError reacts to a failed send. It returns how long to wait before retrying, if telegram told us,
+// and whether the send failed permanently and shouldn't be retried at all.
+func (bot *SecretSquirrel) classifySendError(j *QueueJob, err error) (time.Duration, bool) {
+	bot.Queue.logJob(j.Record).WithError(err).Warn("failed to send message.")
+
+	apiErr, ok := err.(*tgbotapi.Error)
+	if !ok {
+		// network errors and the like.
+		sendErrors.WithLabelValues(errorCodeLabel(0)).Inc()
+		return 0, false
+	}
+	sendErrors.WithLabelValues(errorCodeLabel(apiErr.Code)).Inc()
+
+	switch {
+	// rate-limited
+	case apiErr.Code == 429:
+		return time.Duration(apiErr.ResponseParameters.RetryAfter) * time.Second, false
+	// the user blocked the bot or deleted their account.
+	case apiErr.Code == 403, strings.Contains(apiErr.Message, "chat not found"):
+		bot.leaveUser(j.User)
+		return 0, true
+	// the request itself is bad, sending it again won't help.
+	case apiErr.Code >= 400 && apiErr.Code < 500:
+		return 0, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"secretsquirrel/database"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var (
+	exportFormat string
+	exportRedact database.Redaction
+
+	importArchiveDryRun  bool
+	importKeepExisting   bool
+	importArchiveYes     bool
+	importConflictsLimit int
+
+	exportCmd = &cobra.Command{
+		Use:   "export <path>",
+		Short: "Back up users, the system config and the moderation log to a json or jsonl archive",
+		Long: "Back up users, the system config and the moderation log to a json or jsonl archive.\n" +
+			"Use - as the path to write to stdout. With more than one database, the archive of\n" +
+			"the n-th additional database is written to <path> with .n added before the extension.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run:                   exportArchive,
+	}
+	importCmd = &cobra.Command{
+		Use:   "import <path>",
+		Short: "Restore an archive created with export",
+		Long: "Restore an archive created with export. Users and system config that already exist are\n" +
+			"overwritten and reported as conflicts. Existing mod actions and reports are always kept.\n" +
+			"Fields that were redacted in the archive are never overwritten.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run:                   importArchive,
+	}
+)
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "json or jsonl, by default from the file extension.")
+	exportCmd.Flags().BoolVar(&exportRedact.RealNames, "redact-names", false, "leave real names out of the archive.")
+	exportCmd.Flags().BoolVar(&exportRedact.Usernames, "redact-usernames", false, "leave usernames out of the archive.")
+	exportCmd.Flags().BoolVar(&exportRedact.Tripcodes, "redact-tripcodes", false, "leave tripcodes out of the archive.")
+
+	importCmd.Flags().BoolVar(&importArchiveDryRun, "dry-run", false, "only show what would be imported.")
+	importCmd.Flags().BoolVar(&importKeepExisting, "keep-existing", false, "skip records that already exist instead of overwriting them.")
+	importCmd.Flags().BoolVarP(&importArchiveYes, "yes", "y", false, "import without asking for confirmation.")
+	importCmd.Flags().IntVar(&importConflictsLimit, "conflicts", 20, "maximum number of conflicting keys to list per table.")
+}
+
+// archivePath returns the path the archive of the i-th database is written to.
+func archivePath(path string, i int) string {
+	if i == 0 || path == "-" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), i, ext)
+}
+
+func exportArchive(cmd *cobra.Command, args []string) {
+	format := exportFormat
+	if format == "" {
+		format = "json"
+		if strings.HasSuffix(args[0], ".jsonl") {
+			format = "jsonl"
+		}
+	}
+	if format != "json" && format != "jsonl" {
+		fmt.Println("invalid format, must be json or jsonl")
+		return
+	}
+	if args[0] == "-" && len(databases) > 1 {
+		fmt.Println("only one database can be exported to stdout")
+		return
+	}
+
+	paths := map[*DatabaseWithPath]string{}
+	for i, d := range databases {
+		paths[d] = archivePath(args[0], i)
+	}
+
+	export := func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		path := paths[d]
+
+		a, err := database.ExportArchive(db, exportRedact)
+		if err != nil {
+			return "", err
+		}
+
+		if err := writeArchiveFile(path, a, format == "jsonl"); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"database":    d.path,
+			"path":        path,
+			"users":       len(a.Users),
+			"mod_actions": len(a.ModActions),
+			"reports":     len(a.Reports),
+		}).Info("exported database.")
+
+		return fmt.Sprintf("exported to %s", path), nil
+	}
+
+	// the results table would end up in the archive when it's written to stdout.
+	if args[0] == "-" {
+		for _, d := range databases {
+			if _, err := export(d, d.database); err != nil {
+				logger.WithError(err).WithField("database", d.path).Error("failed to export database.")
+			}
+		}
+		return
+	}
+	readDatabases(export)
+}
+
+// writeArchiveFile writes an archive to path, or to stdout if path is -.
+func writeArchiveFile(path string, a *database.Archive, jsonl bool) error {
+	if path == "-" {
+		return database.WriteArchive(os.Stdout, a, jsonl)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := database.WriteArchive(f, a, jsonl); err != nil {
+		f.Close()
+		return err
+	}
+	// the data may only reach the disk when the file is closed.
+	return f.Close()
+}
+
+func importArchive(cmd *cobra.Command, args []string) {
+	f, err := os.Open(args[0])
+	if err != nil {
+		logger.WithError(err).WithField("path", args[0]).Error("failed to open archive.")
+		return
+	}
+	defer f.Close()
+
+	a, err := database.ReadArchive(f)
+	if err != nil {
+		logger.WithError(err).WithField("path", args[0]).Error("failed to read archive.")
+		return
+	}
+
+	fmt.Printf("Archive version %d, schema version %d, exported %s\n",
+		a.Version, a.SchemaVersion, a.ExportedAt.Format("2006-01-02 15:04:05"))
+
+	// always show what would change first.
+	for _, d := range databases {
+		fmt.Println(d.path)
+
+		results, err := database.ImportArchive(d.database, a, importKeepExisting, true)
+		if err != nil {
+			logger.WithError(err).WithField("database", d.path).Error("failed to import archive.")
+			continue
+		}
+		printImportResults(results)
+	}
+
+	if importArchiveDryRun {
+		fmt.Println("Dry run, nothing was imported.")
+		return
+	}
+	if !importArchiveYes && !confirm("Import into the databases above?") {
+		fmt.Println("Import cancelled.")
+		return
+	}
+
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		results, err := database.ImportArchive(db, a, importKeepExisting, false)
+		if err != nil {
+			return "", err
+		}
+
+		imported := 0
+		fields := logrus.Fields{"database": d.path}
+		for _, r := range results {
+			fields[r.Table] = r.Created + r.Updated
+			imported += r.Created + r.Updated
+		}
+		logger.WithFields(fields).Info("imported archive.")
+
+		return fmt.Sprintf("imported %d records", imported), nil
+	})
+}
+
+func printImportResults(results []database.ArchiveTableResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tCREATED\tUPDATED\tSKIPPED\tCONFLICTS")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", r.Table, r.Created, r.Updated, r.Skipped, len(r.Conflicts))
+	}
+	w.Flush()
+
+	for _, r := range results {
+		if len(r.Conflicts) == 0 {
+			continue
+		}
+
+		conflicts := r.Conflicts
+		if len(conflicts) > importConflictsLimit {
+			conflicts = conflicts[:importConflictsLimit]
+		}
+		more := ""
+		if len(r.Conflicts) > len(conflicts) {
+			more = fmt.Sprintf(" and %d more", len(r.Conflicts)-len(conflicts))
+		}
+		fmt.Printf("conflicts in %s: %s%s\n", r.Table, strings.Join(conflicts, ", "), more)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"secretsquirrel/config"
+	"secretsquirrel/database"
+	"strings"
+	"text/tabwriter"
+
+	"gorm.io/gorm"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// dbResult is the outcome of a command on one database.
+type dbResult struct {
+	database string
+	result   string
+	err      error
+}
+
+// findConfigs returns the config files matching the --all-configs patterns, without duplicates.
+func findConfigs(patterns []string) ([]string, error) {
+	var (
+		paths []string
+		seen  = map[string]bool{}
+	)
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		for _, m := range matches {
+			abs, err := filepath.Abs(m)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[abs] {
+				seen[abs] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// configDatabase returns the database of a config file. Relative sqlite paths are relative to the
+// config file, as the bot is run from the directory of its config.
+func configDatabase(path string, c config.Config) (string, string, string) {
+	driver, dsn := c.Bot.Database()
+	if driver != database.DriverSQLite && driver != "" {
+		return driver, dsn, fmt.Sprintf("%s (%s)", database.Name(driver, dsn), path)
+	}
+
+	if dsn != "" && !filepath.IsAbs(dsn) && !strings.HasPrefix(dsn, "file:") && dsn != ":memory:" {
+		dsn = filepath.Join(filepath.Dir(path), dsn)
+	}
+	return driver, dsn, dsn
+}
+
+// addDatabase connects to a database unless it was added already. Databases that can't be
+// connected to are skipped, so the others can still be managed, and recorded in unreachable.
+func addDatabase(seen map[string]bool, driver string, dsn string, name string) {
+	key := dsn
+	if driver == database.DriverSQLite || driver == "" {
+		if abs, err := filepath.Abs(dsn); err == nil {
+			key = abs
+		}
+	}
+	key = driver + " " + key
+	if seen[key] {
+		return
+	}
+	seen[key] = true
+
+	db, err := database.OpenDB(driver, dsn, logger)
+	if err != nil {
+		logger.WithError(err).WithField("database", name).Error("failed to connect to database.")
+		unreachable = append(unreachable, dbResult{database: name, err: fmt.Errorf("failed to connect: %w", err)})
+		return
+	}
+
+	databases = append(databases, &DatabaseWithPath{path: name, database: db})
+}
+
+// readDatabases runs fn on every database and prints a table of the results. Failing on one database
+// doesn't stop the others. It's meant for commands that don't change anything, so --atomic is ignored.
+func readDatabases(fn func(d *DatabaseWithPath, db *gorm.DB) (string, error)) []dbResult {
+	results := append([]dbResult{}, unreachable...)
+
+	for _, d := range databases {
+		result, err := fn(d, d.database)
+		results = append(results, dbResult{database: d.path, result: result, err: err})
+	}
+	printResults(results)
+	return results
+}
+
+// runOnDatabases runs fn on every database and prints a table of the results. Failing on one
+// database doesn't stop the others, unless --atomic is set: then fn runs in a transaction on every
+// database and the transactions are only committed if fn succeeded on all of them. fn isn't run at all
+// if one of the databases couldn't be connected to.
+func runOnDatabases(fn func(d *DatabaseWithPath, db *gorm.DB) (string, error)) []dbResult {
+	if !atomic {
+		return readDatabases(fn)
+	}
+
+	if len(unreachable) > 0 {
+		results := append([]dbResult{}, unreachable...)
+		for _, d := range databases {
+			results = append(results, dbResult{database: d.path, result: "not run"})
+		}
+		printResults(results)
+		fmt.Println("Nothing was changed, at least one database couldn't be connected to.")
+		return results
+	}
+
+	var (
+		results   []dbResult
+		txs       []*gorm.DB
+		failed    bool
+		committed int
+	)
+	for _, d := range databases {
+		tx := d.database.Begin()
+		if tx.Error != nil {
+			results = append(results, dbResult{database: d.path, err: tx.Error})
+			txs = append(txs, nil)
+			failed = true
+			continue
+		}
+		txs = append(txs, tx)
+
+		result, err := fn(d, tx)
+		results = append(results, dbResult{database: d.path, result: result, err: err})
+		if err != nil {
+			failed = true
+		}
+	}
+
+	for i, tx := range txs {
+		if tx == nil {
+			continue
+		}
+
+		if failed {
+			tx.Rollback()
+			if results[i].err == nil {
+				results[i].result = "rolled back"
+			}
+			continue
+		}
+		// a commit that fails now can't undo the ones before it, the databases don't share a transaction.
+		// The databases after it are rolled back, so at least they aren't changed as well.
+		if err := tx.Commit().Error; err != nil {
+			if committed > 0 {
+				err = fmt.Errorf("partially applied, %d database(s) before this one were already committed: %w", committed, err)
+			}
+			results[i].err = err
+			failed = true
+			continue
+		}
+		committed++
+	}
+
+	printResults(results)
+	switch {
+	case failed && committed > 0:
+		fmt.Println("The command was only applied to the databases that were committed before the failure.")
+	case failed:
+		fmt.Println("Nothing was changed, the command failed on at least one database.")
+	}
+	return results
+}
+
+func printResults(results []dbResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tRESULT\tERROR")
+	for _, r := range results {
+		result, errText := r.result, ""
+		if r.err != nil {
+			result, errText = "failed", r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.database, result, errText)
+	}
+	w.Flush()
+}
+
+// findUser looks a user up by username or id, it returns errUserNotFound if there is none.
+func findUser(db *gorm.DB, s string) (*database.User, error) {
+	user, err := database.FindUser(db, database.ByUsernameOrID(s))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errUserNotFound
+	}
+	return user, err
+}
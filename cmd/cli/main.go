@@ -3,11 +3,16 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"os"
 	"secretsquirrel/config"
 	"secretsquirrel/database"
+	"secretsquirrel/logging"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"gorm.io/gorm"
 )
@@ -19,9 +24,20 @@ type DatabaseWithPath struct {
 
 var (
 	cfg       config.Config
+	logger    *logrus.Logger
 	databases []*DatabaseWithPath
+	// unreachable are the databases that couldn't be connected to, they're shown in every results table.
+	unreachable []dbResult
 
 	extraDBPaths []string
+	allConfigs   []string
+	atomic       bool
+
+	modLogActor  string
+	modLogTarget string
+	modLogAction string
+	modLogSince  time.Duration
+	modLogLimit  int
 
 	rootCmd = &cobra.Command{
 		Use:   "secretsqcli",
@@ -29,6 +45,11 @@ var (
 		CompletionOptions: cobra.CompletionOptions{
 			DisableDefaultCmd: true,
 		},
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			if cmd != migrateCmd && cmd.Parent() != migrateCmd {
+				checkSchema()
+			}
+		},
 	}
 	banCmd = &cobra.Command{
 		Use:                   "ban [username | id] <reason>",
@@ -51,131 +72,267 @@ var (
 		DisableFlagsInUseLine: true,
 		Run:                   setRank,
 	}
+	modLogCmd = &cobra.Command{
+		Use:   "modlog",
+		Short: "Show the moderation log",
+		Args:  cobra.NoArgs,
+		Run:   modLog,
+	}
 )
 
 func main() {
 	cobra.OnInitialize(initCobra)
 
-	rootCmd.PersistentFlags().StringSliceVarP(&extraDBPaths, "database", "d", []string{}, "additional database locations.")
+	rootCmd.PersistentFlags().StringSliceVarP(&extraDBPaths, "database", "d", []string{}, "additional databases: sqlite paths, postgres:// urls or mysql:// dsns.")
+	rootCmd.PersistentFlags().StringSliceVar(&allConfigs, "all-configs", nil, "use the databases of every config file matching these patterns instead of ./config.yml. Without a value: config.yml,*/config.yml.")
+	rootCmd.PersistentFlags().Lookup("all-configs").NoOptDefVal = "config.yml,*/config.yml"
+	rootCmd.PersistentFlags().BoolVar(&atomic, "atomic", false, "only apply changes if they succeed on every database.")
 
 	rootCmd.AddCommand(banCmd)
 	rootCmd.AddCommand(unbanCmd)
 	rootCmd.AddCommand(setRankCmd)
+
+	modLogCmd.Flags().StringVar(&modLogActor, "actor", "", "only show actions taken by this user (username or id, 0 for secretsqcli).")
+	modLogCmd.Flags().StringVar(&modLogTarget, "target", "", "only show actions taken on this user (username or id).")
+	modLogCmd.Flags().StringVar(&modLogAction, "action", "", "only show actions of this kind.")
+	modLogCmd.Flags().DurationVar(&modLogSince, "since", 0, "only show actions taken within this duration, e.g. 24h.")
+	modLogCmd.Flags().IntVarP(&modLogLimit, "limit", "n", 50, "maximum number of actions to show.")
+	rootCmd.AddCommand(modLogCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(importSecretLoungeCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
 	rootCmd.Execute()
 }
 
 func initCobra() {
-	config.LoadConfig(&cfg)
-	initDB()
+	var (
+		configs     []config.Config
+		configPaths []string
+		err         error
+	)
+
+	if len(allConfigs) == 0 {
+		config.LoadConfig(&cfg)
+		configs = append(configs, cfg)
+	} else {
+		if configPaths, err = findConfigs(allConfigs); err != nil {
+			log.Fatalf("Error finding config files, %s", err)
+		}
+		for _, path := range configPaths {
+			c, err := config.LoadConfigFile(path)
+			if err != nil {
+				log.Fatalf("Error reading config file %s, %s", path, err)
+			}
+			configs = append(configs, c)
+		}
+		if len(configs) == 0 {
+			log.Fatalf("No config files match %s", strings.Join(allConfigs, ", "))
+		}
+		// the first config sets up logging.
+		cfg = configs[0]
+	}
+
+	if logger, err = logging.New(&cfg); err != nil {
+		log.Fatalf("Error setting up logging, %s", err)
+	}
+	initDB(configs, configPaths)
 }
 
-func initDB() {
-	databases = append(databases,
-		&DatabaseWithPath{
-			path:     cfg.Bot.DatabasePath,
-			database: database.InitDB(cfg.Bot.DatabasePath),
-		},
-	)
+// initDB connects to the databases of the configs and the --database flag.
+func initDB(configs []config.Config, configPaths []string) {
+	seen := map[string]bool{}
 
-	for len(extraDBPaths) > 0 {
-		for _, path := range extraDBPaths {
-			databases = append(databases,
-				&DatabaseWithPath{
-					path:     path,
-					database: database.InitDB(path),
-				})
+	for i, c := range configs {
+		if i < len(configPaths) {
+			driver, dsn, name := configDatabase(configPaths[i], c)
+			addDatabase(seen, driver, dsn, name)
+			continue
 		}
+		driver, dsn := c.Bot.Database()
+		addDatabase(seen, driver, dsn, database.Name(driver, dsn))
+	}
+
+	for _, path := range extraDBPaths {
+		driver, dsn := database.ParseDatabase(path)
+		addDatabase(seen, driver, dsn, database.Name(driver, dsn))
 	}
 
 	if len(databases) == 0 {
-		panic("No databases specified.")
+		log.Fatalf("No databases to use.")
 	}
 }
 
 func setRank(cmd *cobra.Command, args []string) {
-	var rank database.UserRank
+	var (
+		rank   database.UserRank
+		action database.ModActionType
+	)
 
 	switch args[1] {
 	case "admin":
 		rank = database.RankAdmin
+		action = database.ActionPromoteAdmin
 	case "mod":
 		rank = database.RankMod
+		action = database.ActionPromoteMod
 	case "user":
 		rank = database.RankUser
+		action = database.ActionDemote
 	default:
 		fmt.Println("invalid rank")
 		return
 	}
 
-	for _, d := range databases {
-		var (
-			user *database.User
-			err  error
-		)
-
-		fmt.Println(d.path)
-
-		user, err = database.FindUser(d.database, database.ByUsernameOrID(args[0]))
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		user, err := findUser(db, args[0])
 		if err != nil {
-			fmt.Println(err)
-			return
+			return "", err
 		}
 
-		d.database.Model(user).Update("rank", rank)
-	}
-
-	fmt.Printf("User: %v New Rank: %v", args[0], rank.String())
+		if err := db.Model(user).Update("rank", rank).Error; err != nil {
+			return "", err
+		}
+		if err := saveModAction(db, action, user.ID, ""); err != nil {
+			return "", err
+		}
+		logger.WithFields(logrus.Fields{
+			"database": d.path,
+			"user":     logging.UserID(user.ID),
+			"rank":     rank.String(),
+		}).Info("set rank.")
+
+		return "rank set to " + rank.String(), nil
+	})
 }
 
 func banUser(cmd *cobra.Command, args []string) {
 
 	reason := strings.Join(args[1:], " ")
 
-	for _, d := range databases {
-		var (
-			user *database.User
-			err  error
-		)
-
-		fmt.Println(d.path)
-
-		user, err = database.FindUser(d.database, database.ByUsernameOrID(args[0]))
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		user, err := findUser(db, args[0])
 		if err != nil {
-			fmt.Println(err)
-			return
+			return "", err
 		}
 
-		d.database.Model(user).Updates(database.User{
+		err = db.Model(user).Updates(database.User{
 			Rank:            database.RankBanned,
 			Left:            sql.NullTime{Time: time.Now(), Valid: true},
 			BlacklistReason: reason,
-		})
-	}
+		}).Error
+		if err != nil {
+			return "", err
+		}
+		if err := saveModAction(db, database.ActionBlacklist, user.ID, reason); err != nil {
+			return "", err
+		}
+		logger.WithFields(logrus.Fields{
+			"database": d.path,
+			"user":     logging.UserID(user.ID),
+		}).Info("banned user.")
 
-	fmt.Printf("User banned.")
+		return "banned", nil
+	})
 }
 
 func unbanUser(cmd *cobra.Command, args []string) {
 
-	for _, d := range databases {
-		var (
-			user *database.User
-			err  error
-		)
-
-		fmt.Println(d.path)
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		user, err := findUser(db, args[0])
+		if err != nil {
+			return "", err
+		}
 
-		user, err = database.FindUser(d.database, database.ByUsernameOrID(args[0]))
+		// a map, so the empty reason is written too.
+		err = db.Model(user).Updates(map[string]interface{}{
+			"rank":             database.RankUser,
+			"blacklist_reason": "",
+		}).Error
 		if err != nil {
-			fmt.Println(err)
-			return
+			return "", err
+		}
+		if err := saveModAction(db, database.ActionUnblacklist, user.ID, ""); err != nil {
+			return "", err
 		}
+		logger.WithFields(logrus.Fields{
+			"database": d.path,
+			"user":     logging.UserID(user.ID),
+		}).Info("unbanned user.")
 
-		d.database.Model(user).Updates(database.User{
-			Rank:            database.RankUser,
-			BlacklistReason: "",
-		})
+		return "unbanned", nil
+	})
+}
+
+// saveModAction records an action taken through secretsqcli in the moderation log.
+func saveModAction(db *gorm.DB, action database.ModActionType, targetID int64, reason string) error {
+	return database.SaveModAction(db, database.NewModAction(action, database.ConsoleActorID, targetID, reason, -1))
+}
+
+// findActorID resolves the --actor filter. 0 stands for actions taken through secretsqcli.
+func findActorID(db *gorm.DB, s string) (int64, error) {
+	if s == "0" {
+		return database.ConsoleActorID, nil
 	}
 
-	fmt.Printf("User unbanned.")
+	user, err := database.FindUser(db, database.ByUsernameOrID(s))
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func modLog(cmd *cobra.Command, args []string) {
+	var baseScopes []func(*gorm.DB) *gorm.DB
+
+	if modLogAction != "" {
+		action, ok := database.ParseModActionType(modLogAction)
+		if !ok {
+			fmt.Println("invalid action, must be one of:", database.ModActionTypes)
+			return
+		}
+		baseScopes = append(baseScopes, database.ModActionsByAction(action))
+	}
+	if modLogSince > 0 {
+		baseScopes = append(baseScopes, database.ModActionsSince(time.Now().Add(-modLogSince)))
+	}
+
+	readDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		scopes := baseScopes
+
+		if modLogActor != "" {
+			actorID, err := findActorID(db, modLogActor)
+			if err != nil {
+				return "", fmt.Errorf("failed to find actor: %w", err)
+			}
+			scopes = append(scopes, database.ModActionsByActor(actorID))
+		}
+		if modLogTarget != "" {
+			target, err := findUser(db, modLogTarget)
+			if err != nil {
+				return "", fmt.Errorf("failed to find target: %w", err)
+			}
+			scopes = append(scopes, database.ModActionsByTarget(target.ID))
+		}
+
+		actions, err := database.FindModActions(db, modLogLimit, scopes...)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Println(d.path)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tACTION\tACTOR\tTARGET\tMESSAGE\tREASON")
+		for _, a := range actions {
+			msid := "-"
+			if a.CacheMessageID.Valid {
+				msid = fmt.Sprint(a.CacheMessageID.Int64)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%s\t%s\n",
+				a.ID, a.CreatedAt.Format("2006-01-02 15:04:05"), a.Action, a.ActorID, a.TargetID, msid, a.Reason)
+		}
+		w.Flush()
+
+		return fmt.Sprintf("%d actions", len(actions)), nil
+	})
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"secretsquirrel/database"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var (
+	migrateForce bool
+
+	migrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+	}
+	migrateStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show which migrations have been applied",
+		Args:  cobra.NoArgs,
+		Run:   migrateStatus,
+	}
+	migrateUpCmd = &cobra.Command{
+		Use:   "up",
+		Short: "Apply every pending migration",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			runMigration(database.MigrateUp)
+		},
+	}
+	migrateDownCmd = &cobra.Command{
+		Use:   "down [steps]",
+		Short: "Revert the last migration, or the given number of migrations",
+		Long: "Revert the last migration, or the given number of migrations. The first migration,\n" +
+			"which creates the users table, is only reverted with --force.",
+		Args:                  cobra.MaximumNArgs(1),
+		DisableFlagsInUseLine: true,
+		Run:                   migrateDown,
+	}
+	migrateToCmd = &cobra.Command{
+		Use:   "to <version>",
+		Short: "Apply or revert migrations until the given version is reached",
+		Long: "Apply or revert migrations until the given version is reached. Version 0 reverts every\n" +
+			"migration and deletes every user, it's only allowed with --force.",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run:                   migrateTo,
+	}
+)
+
+func init() {
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateToCmd)
+
+	migrateDownCmd.Flags().BoolVar(&migrateForce, "force", false, "allow reverting the first migration.")
+	migrateToCmd.Flags().BoolVar(&migrateForce, "force", false, "allow reverting the first migration.")
+}
+
+// checkSchema warns about databases whose schema doesn't match this version of secretsqcli.
+func checkSchema() {
+	for _, d := range databases {
+		version, err := database.CurrentVersion(d.database)
+		if err != nil {
+			logger.WithError(err).WithField("database", d.path).Error("failed to read schema version.")
+			continue
+		}
+		if version != database.LatestVersion() {
+			logger.WithFields(map[string]interface{}{
+				"database": d.path,
+				"version":  version,
+				"latest":   database.LatestVersion(),
+			}).Warn("database schema is out of date, run secretsqcli migrate up.")
+		}
+	}
+}
+
+func runMigration(migrate func(db *gorm.DB) (int, error)) {
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		version, err := migrate(db)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("schema version %d", version), nil
+	})
+}
+
+func migrateStatus(cmd *cobra.Command, args []string) {
+	readDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			return "", err
+		}
+
+		pending := 0
+		fmt.Println(d.path)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			} else {
+				pending++
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+
+		return fmt.Sprintf("%d pending migrations", pending), nil
+	})
+}
+
+func migrateDown(cmd *cobra.Command, args []string) {
+	steps := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			fmt.Println("invalid number of steps")
+			return
+		}
+		steps = n
+	}
+
+	runMigration(func(db *gorm.DB) (int, error) {
+		return database.MigrateDown(db, steps, migrateForce)
+	})
+}
+
+func migrateTo(cmd *cobra.Command, args []string) {
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Println("invalid version")
+		return
+	}
+	if version < 1 && !migrateForce {
+		fmt.Println("version 0 reverts every migration and deletes every user, use --force to do it anyway")
+		return
+	}
+
+	runMigration(func(db *gorm.DB) (int, error) {
+		return database.Migrate(db, version)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"secretsquirrel/database"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var (
+	importDryRun bool
+	importYes    bool
+
+	importSecretLoungeCmd = &cobra.Command{
+		Use:                   "import-secretlounge <path>",
+		Short:                 "Import users and the motd from a secretlounge-ng sqlite or json database",
+		Args:                  cobra.ExactArgs(1),
+		DisableFlagsInUseLine: true,
+		Run:                   importSecretLounge,
+	}
+)
+
+func init() {
+	importSecretLoungeCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only show what would be imported.")
+	importSecretLoungeCmd.Flags().BoolVarP(&importYes, "yes", "y", false, "import without asking for confirmation.")
+}
+
+func importSecretLounge(cmd *cobra.Command, args []string) {
+	imp, err := database.ReadSecretLounge(args[0])
+	if err != nil {
+		logger.WithError(err).WithField("path", args[0]).Error("failed to read secretlounge-ng database.")
+		return
+	}
+
+	for _, d := range databases {
+		fmt.Println(d.path)
+		printSecretLoungeReport(d, imp)
+	}
+	for _, s := range imp.Skipped {
+		fmt.Println("skipped", s)
+	}
+	for _, s := range imp.Warnings {
+		fmt.Println("warning", s)
+	}
+
+	if importDryRun {
+		fmt.Println("Dry run, nothing was imported.")
+		return
+	}
+	if len(imp.Users) == 0 && imp.Motd == "" {
+		fmt.Println("Nothing to import.")
+		return
+	}
+	if !importYes && !confirm("Import into the databases above?") {
+		fmt.Println("Import cancelled.")
+		return
+	}
+
+	runOnDatabases(func(d *DatabaseWithPath, db *gorm.DB) (string, error) {
+		if err := database.SaveSecretLoungeImport(db, imp); err != nil {
+			return "", err
+		}
+		logger.WithFields(logrus.Fields{
+			"database": d.path,
+			"users":    len(imp.Users),
+		}).Info("imported secretlounge-ng database.")
+
+		return fmt.Sprintf("imported %d users", len(imp.Users)), nil
+	})
+}
+
+// printSecretLoungeReport summarizes what importing would change in a database.
+func printSecretLoungeReport(d *DatabaseWithPath, imp *database.SecretLoungeImport) {
+	var (
+		existing    []int64
+		ids         []int64
+		ranks       = map[database.UserRank]int{}
+		blacklisted int
+		cooldowns   int
+		warned      int
+		tripcodes   int
+		karma       int
+	)
+
+	now := time.Now()
+	for _, u := range imp.Users {
+		ids = append(ids, u.ID)
+		ranks[u.Rank]++
+		if u.BlacklistReason != "" {
+			blacklisted++
+		}
+		if u.CooldownUntil.Valid && u.CooldownUntil.Time.After(now) {
+			cooldowns++
+		}
+		if u.Warnings > 0 {
+			warned++
+		}
+		if u.Tripcode != "" {
+			tripcodes++
+		}
+		karma += u.Karma
+	}
+
+	// look the ids up in batches, some databases limit the number of query parameters.
+	for i := 0; i < len(ids); i += 500 {
+		var found []int64
+
+		end := i + 500
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := d.database.Model(&database.User{}).Where("id IN ?", ids[i:end]).Pluck("id", &found).Error; err != nil {
+			logger.WithError(err).WithField("database", d.path).Error("failed to look up existing users.")
+			return
+		}
+		existing = append(existing, found...)
+	}
+
+	motd := "unchanged"
+	if imp.Motd != "" && imp.Motd != database.GetMotd(d.database) {
+		motd = "replaced"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Users\t%d\n", len(imp.Users))
+	fmt.Fprintf(w, "  new\t%d\n", len(imp.Users)-len(existing))
+	fmt.Fprintf(w, "  overwritten\t%d\n", len(existing))
+	for _, rank := range []database.UserRank{database.RankAdmin, database.RankMod, database.RankUser, database.RankBanned} {
+		fmt.Fprintf(w, "  %s\t%d\n", strings.ToLower(rank.String()), ranks[rank])
+	}
+	fmt.Fprintf(w, "Blacklisted\t%d\n", blacklisted)
+	fmt.Fprintf(w, "In cooldown\t%d\n", cooldowns)
+	fmt.Fprintf(w, "With warnings\t%d\n", warned)
+	fmt.Fprintf(w, "With tripcodes\t%d\n", tripcodes)
+	fmt.Fprintf(w, "Total karma\t%d\n", karma)
+	fmt.Fprintf(w, "MOTD\t%s\n", motd)
+	fmt.Fprintf(w, "Skipped\t%d\n", len(imp.Skipped))
+	w.Flush()
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
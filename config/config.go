@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/spf13/viper"
@@ -12,12 +13,40 @@ type Config struct {
 	Cooldown CooldownConfig
 	Karma    KarmaConfig
 	Spam     SpamConfig
+	Queue    QueueConfig
+	Metrics  MetricsConfig
+	Log      LogConfig
 }
 
 type BotConfig struct {
-	Token            string
+	Token string
+	// DatabaseDriver is one of sqlite, postgres or mysql. DatabasePath is used as the dsn of sqlite
+	// databases if DatabaseDSN is empty.
+	DatabaseDriver   string
+	DatabaseDSN      string
 	DatabasePath     string
 	BlacklistContact string
+	Webhook          WebhookConfig
+	// CallbackSecret signs the data of inline keyboard buttons. It defaults to the bot token.
+	CallbackSecret string
+}
+
+// Database returns the driver and dsn of the bot's database.
+func (c BotConfig) Database() (string, string) {
+	if c.DatabaseDSN == "" {
+		return c.DatabaseDriver, c.DatabasePath
+	}
+	return c.DatabaseDriver, c.DatabaseDSN
+}
+
+// WebhookConfig configures receiving updates through a webhook. Long polling is used if URL is empty.
+type WebhookConfig struct {
+	URL         string
+	Listen      string
+	Path        string
+	SecretToken string
+	CertFile    string
+	KeyFile     string
 }
 
 type LimitsConfig struct {
@@ -53,11 +82,47 @@ type SpamConfig struct {
 	ScoreTextLineBreak float32
 }
 
+type QueueConfig struct {
+	Workers         int
+	GlobalRateLimit int
+	ChatRateLimit   int
+}
+
+// MetricsConfig configures the prometheus endpoint. It is disabled if Listen is empty.
+type MetricsConfig struct {
+	Listen string
+	Path   string
+}
+
+// LogConfig configures the logger. Logs go to stderr if File is empty.
+// User ids are only logged hashed with HashKey, which defaults to the bot token.
+type LogConfig struct {
+	Level   string
+	Format  string
+	File    string
+	HashKey string
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("bot.databaseDriver", "sqlite")
+	v.SetDefault("limits.signLimitInterval", 600)
+	v.SetDefault("bot.webhook.listen", ":8443")
+	v.SetDefault("bot.webhook.path", "/")
+	v.SetDefault("queue.workers", 4)
+	v.SetDefault("queue.globalRateLimit", 30)
+	v.SetDefault("queue.chatRateLimit", 1)
+	v.SetDefault("metrics.path", "/metrics")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "text")
+}
+
 func LoadConfig(cfg *Config) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 
+	setDefaults(viper.GetViper())
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Error reading config file, %s", err)
 	}
@@ -66,4 +131,45 @@ func LoadConfig(cfg *Config) {
 	if err != nil {
 		log.Fatalf("Error unmarshalling config file, %s", err)
 	}
+
+	if err := cfg.validate(); err != nil {
+		log.Fatalf("Error in config file, %s", err)
+	}
+}
+
+// LoadConfigFile reads the config file at path, e.g. the config of another lounge.
+func LoadConfigFile(path string) (Config, error) {
+	var cfg Config
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	setDefaults(v)
+
+	if err := v.ReadInConfig(); err != nil {
+		return cfg, err
+	}
+	if err := v.Unmarshal(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+// validate rejects queue settings the bot can't run with. Without workers nothing is ever relayed,
+// and the rate limits are requests per second.
+func (cfg *Config) validate() error {
+	checks := []struct {
+		name  string
+		value int
+	}{
+		{"queue.workers", cfg.Queue.Workers},
+		{"queue.globalRateLimit", cfg.Queue.GlobalRateLimit},
+		{"queue.chatRateLimit", cfg.Queue.ChatRateLimit},
+	}
+	for _, c := range checks {
+		if c.value < 1 {
+			return fmt.Errorf("%s must be at least 1, got %d", c.name, c.value)
+		}
+	}
+	return nil
 }
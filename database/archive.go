@@ -0,0 +1,397 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ArchiveVersion is the version of the archive format written by WriteArchive.
+const ArchiveVersion = 1
+
+// archive line types of the jsonl format.
+const (
+	archiveHeader       = "header"
+	archiveUser         = "user"
+	archiveSystemConfig = "system_config"
+	archiveModAction    = "mod_action"
+	archiveReport       = "report"
+)
+
+var errDryRun = errors.New("dry run")
+
+// Redaction lists the user data left out of an archive.
+type Redaction struct {
+	RealNames bool `json:"realNames"`
+	Usernames bool `json:"usernames"`
+	Tripcodes bool `json:"tripcodes"`
+}
+
+// ArchiveHeader describes an archive.
+type ArchiveHeader struct {
+	Version       int       `json:"version"`
+	SchemaVersion int       `json:"schemaVersion"`
+	ExportedAt    time.Time `json:"exportedAt"`
+	Redacted      Redaction `json:"redacted"`
+}
+
+// Archive is a backup of the users, the system config and the moderation tables of a lounge.
+// The message cache and the outbound queue are short-lived and not part of it.
+type Archive struct {
+	ArchiveHeader
+	Users        []User         `json:"users"`
+	SystemConfig []SystemConfig `json:"systemConfig"`
+	ModActions   []ModAction    `json:"modActions"`
+	Reports      []Report       `json:"reports"`
+}
+
+type archiveLine struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// ArchiveTableResult counts what importing did to a table. Conflicts are the keys of records that already existed.
+type ArchiveTableResult struct {
+	Table     string
+	Created   int
+	Updated   int
+	Skipped   int
+	Conflicts []string
+}
+
+// ExportArchive reads the archived tables of a database.
+func ExportArchive(db *gorm.DB, redact Redaction) (*Archive, error) {
+	version, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		ArchiveHeader: ArchiveHeader{
+			Version:       ArchiveVersion,
+			SchemaVersion: version,
+			ExportedAt:    time.Now(),
+			Redacted:      redact,
+		},
+	}
+
+	if err := db.Order("id").Find(&a.Users).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Order("name").Find(&a.SystemConfig).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Order("id").Find(&a.ModActions).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Order("id").Find(&a.Reports).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range a.Users {
+		if redact.RealNames {
+			a.Users[i].RealName = ""
+		}
+		if redact.Usernames {
+			a.Users[i].UserName = ""
+		}
+		if redact.Tripcodes {
+			a.Users[i].Tripcode = ""
+		}
+	}
+
+	return a, nil
+}
+
+// WriteArchive writes an archive as a single json document, or as jsonl with one record per line.
+func WriteArchive(w io.Writer, a *Archive, jsonl bool) error {
+	if !jsonl {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(a)
+	}
+
+	enc := json.NewEncoder(w)
+	write := func(typ string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(archiveLine{Type: typ, Data: data})
+	}
+
+	if err := write(archiveHeader, a.ArchiveHeader); err != nil {
+		return err
+	}
+	for _, u := range a.Users {
+		if err := write(archiveUser, u); err != nil {
+			return err
+		}
+	}
+	for _, c := range a.SystemConfig {
+		if err := write(archiveSystemConfig, c); err != nil {
+			return err
+		}
+	}
+	for _, m := range a.ModActions {
+		if err := write(archiveModAction, m); err != nil {
+			return err
+		}
+	}
+	for _, r := range a.Reports {
+		if err := write(archiveReport, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadArchive reads an archive written by WriteArchive in either format.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	var (
+		a     Archive
+		first json.RawMessage
+		line  archiveLine
+	)
+
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&first); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+
+	if err := json.Unmarshal(first, &line); err != nil || line.Type == "" {
+		// a single json document.
+		if err := json.Unmarshal(first, &a); err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		return &a, checkArchiveVersion(&a)
+	}
+
+	for n := 1; ; n++ {
+		var err error
+
+		switch line.Type {
+		case archiveHeader:
+			err = json.Unmarshal(line.Data, &a.ArchiveHeader)
+		case archiveUser:
+			var u User
+			err = json.Unmarshal(line.Data, &u)
+			a.Users = append(a.Users, u)
+		case archiveSystemConfig:
+			var c SystemConfig
+			err = json.Unmarshal(line.Data, &c)
+			a.SystemConfig = append(a.SystemConfig, c)
+		case archiveModAction:
+			var m ModAction
+			err = json.Unmarshal(line.Data, &m)
+			a.ModActions = append(a.ModActions, m)
+		case archiveReport:
+			var r Report
+			err = json.Unmarshal(line.Data, &r)
+			a.Reports = append(a.Reports, r)
+		default:
+			err = fmt.Errorf("unknown record type %q", line.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive record %d: %w", n, err)
+		}
+
+		line = archiveLine{}
+		if err := dec.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid archive record %d: %w", n+1, err)
+		}
+	}
+
+	return &a, checkArchiveVersion(&a)
+}
+
+func checkArchiveVersion(a *Archive) error {
+	if a.Version == 0 {
+		return errors.New("invalid archive: missing version")
+	}
+	if a.Version > ArchiveVersion {
+		return fmt.Errorf("archive version %d is newer than this version of secretsquirrel supports (%d)", a.Version, ArchiveVersion)
+	}
+	return nil
+}
+
+// ImportArchive upserts the records of an archive in one transaction. Users and system config that already
+// exist are overwritten, or skipped with keepExisting, and reported as conflicts. Mod actions and reports are
+// an audit log and never overwritten, existing ones are always skipped. Redacted fields never overwrite
+// existing data. With dryRun the transaction is rolled back, so the results show what importing would do.
+func ImportArchive(db *gorm.DB, a *Archive, keepExisting bool, dryRun bool) ([]ArchiveTableResult, error) {
+	var results []ArchiveTableResult
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if a.SchemaVersion > current {
+		return nil, fmt.Errorf("archive has schema version %d but the database has %d, migrate it first", a.SchemaVersion, current)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var omit []string
+		if a.Redacted.RealNames {
+			omit = append(omit, "real_name")
+		}
+		if a.Redacted.Usernames {
+			omit = append(omit, "user_name")
+		}
+		if a.Redacted.Tripcodes {
+			omit = append(omit, "tripcode")
+		}
+
+		userIDs := make([]interface{}, len(a.Users))
+		for i, u := range a.Users {
+			userIDs[i] = u.ID
+		}
+		res, err := importTable(tx, "users", &User{}, a.Users, userIDs, omit, keepExisting)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+
+		names := make([]interface{}, len(a.SystemConfig))
+		for i, c := range a.SystemConfig {
+			names[i] = c.Name
+		}
+		res, err = importTable(tx, "system_configs", &SystemConfig{}, a.SystemConfig, names, nil, keepExisting)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+
+		actionIDs := make([]interface{}, len(a.ModActions))
+		for i, m := range a.ModActions {
+			actionIDs[i] = m.ID
+		}
+		res, err = importTable(tx, "mod_actions", &ModAction{}, a.ModActions, actionIDs, nil, true)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+
+		reportIDs := make([]interface{}, len(a.Reports))
+		for i, r := range a.Reports {
+			reportIDs[i] = r.ID
+		}
+		res, err = importTable(tx, "reports", &Report{}, a.Reports, reportIDs, nil, true)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+
+		// setval isn't undone by a rollback, so it's left out of dry runs.
+		if dryRun {
+			return errDryRun
+		}
+		return resetSequences(tx, "mod_actions", "reports")
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// importTable upserts records, a slice of model, whose primary keys are keys.
+func importTable(tx *gorm.DB, table string, model interface{}, records interface{}, keys []interface{}, omit []string, keepExisting bool) (ArchiveTableResult, error) {
+	res := ArchiveTableResult{Table: table}
+	if len(keys) == 0 {
+		return res, nil
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return res, err
+	}
+	pk := stmt.Schema.PrioritizedPrimaryField.DBName
+
+	existing := map[string]bool{}
+	// look the keys up in batches, some databases limit the number of query parameters.
+	for i := 0; i < len(keys); i += 500 {
+		var found []interface{}
+
+		end := i + 500
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := tx.Model(model).Where(clause.IN{Column: pk, Values: keys[i:end]}).Pluck(pk, &found).Error; err != nil {
+			return res, err
+		}
+		for _, k := range found {
+			existing[archiveKey(k)] = true
+		}
+	}
+
+	for _, k := range keys {
+		if existing[archiveKey(k)] {
+			res.Conflicts = append(res.Conflicts, archiveKey(k))
+		}
+	}
+	res.Created = len(keys) - len(res.Conflicts)
+	if keepExisting {
+		res.Skipped = len(res.Conflicts)
+	} else {
+		res.Updated = len(res.Conflicts)
+	}
+
+	onConflict := clause.OnConflict{Columns: []clause.Column{{Name: pk}}}
+	switch {
+	case keepExisting:
+		onConflict.DoNothing = true
+	case len(omit) > 0:
+		var columns []string
+		for _, name := range stmt.Schema.DBNames {
+			if name != pk && !contains(omit, name) {
+				columns = append(columns, name)
+			}
+		}
+		onConflict.DoUpdates = clause.AssignmentColumns(columns)
+	default:
+		onConflict.UpdateAll = true
+	}
+
+	return res, tx.Clauses(onConflict).CreateInBatches(records, 100).Error
+}
+
+// archiveKey formats a primary key the same way, whichever type the driver scanned it as.
+func archiveKey(k interface{}) string {
+	if b, ok := k.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(k)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// resetSequences moves postgres' id sequences past imported ids, which were inserted explicitly.
+// The other databases keep track of that themselves.
+func resetSequences(tx *gorm.DB, tables ...string) error {
+	if tx.Dialector.Name() != DriverPostgres {
+		return nil
+	}
+
+	for _, table := range tables {
+		err := tx.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %s", table, table)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
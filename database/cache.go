@@ -0,0 +1,94 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CachedMessage is the persisted metadata of a relayed message.
+type CachedMessage struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	UserID   int64
+	Time     time.Time
+	Warned   bool
+	Reported bool
+	Signed   bool
+	Tripcode bool
+	Official string
+}
+
+// CachedUpvote records that a user has given karma to a cached message.
+type CachedUpvote struct {
+	MessageID int   `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+}
+
+// CachedMapping maps a cached message to the telegram message id a user received.
+type CachedMapping struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID int   `gorm:"primaryKey;autoIncrement:false"`
+	MappedID  int
+}
+
+func SaveCachedMessage(db *gorm.DB, cm *CachedMessage) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(cm).Error
+}
+
+func SetCachedMessageWarned(db *gorm.DB, msid int) error {
+	return db.Model(&CachedMessage{}).Where("id = ?", msid).Update("warned", true).Error
+}
+
+func SetCachedMessageReported(db *gorm.DB, msid int) error {
+	return db.Model(&CachedMessage{}).Where("id = ?", msid).Update("reported", true).Error
+}
+
+func SaveCachedUpvote(db *gorm.DB, msid int, userID int64) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&CachedUpvote{MessageID: msid, UserID: userID}).Error
+}
+
+func SaveCachedMappings(db *gorm.DB, mappings []CachedMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(mappings, 100).Error
+}
+
+func DeleteCachedMappings(db *gorm.DB, msids ...int) error {
+	return db.Where("message_id IN ?", msids).Delete(&CachedMapping{}).Error
+}
+
+// DeleteCachedMessages removes cached messages along with their upvotes and mappings.
+func DeleteCachedMessages(db *gorm.DB, msids ...int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN ?", msids).Delete(&CachedMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN ?", msids).Delete(&CachedUpvote{}).Error; err != nil {
+			return err
+		}
+		return DeleteCachedMappings(tx, msids...)
+	})
+}
+
+// LoadCache returns every persisted cached message, upvote and mapping.
+func LoadCache(db *gorm.DB) ([]CachedMessage, []CachedUpvote, []CachedMapping, error) {
+	var (
+		cms      []CachedMessage
+		upvotes  []CachedUpvote
+		mappings []CachedMapping
+	)
+
+	if err := db.Find(&cms).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	if err := db.Find(&upvotes).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	if err := db.Find(&mappings).Error; err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cms, upvotes, mappings, nil
+}
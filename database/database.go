@@ -1,22 +1,141 @@
 package database
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-func InitDB(path string) *gorm.DB {
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
 
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
-	})
+// dialector returns the gorm dialector of a database driver.
+func dialector(driver string, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case DriverSQLite, "":
+		return sqlite.Open(dsn), nil
+	case DriverPostgres:
+		return postgres.Open(dsn), nil
+	case DriverMySQL:
+		// time.Time columns can't be scanned without parseTime.
+		if !strings.Contains(dsn, "parseTime=") {
+			if strings.Contains(dsn, "?") {
+				dsn += "&parseTime=true"
+			} else {
+				dsn += "?parseTime=true"
+			}
+		}
+		return mysql.Open(dsn), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
+
+// ParseDatabase guesses the driver of a database given on the command line.
+// Postgres urls and mysql dsns prefixed with mysql:// are recognized, anything else is a sqlite path.
+func ParseDatabase(s string) (string, string) {
+	switch {
+	case strings.HasPrefix(s, "postgres://"), strings.HasPrefix(s, "postgresql://"):
+		return DriverPostgres, s
+	case strings.HasPrefix(s, "mysql://"):
+		return DriverMySQL, strings.TrimPrefix(s, "mysql://")
+	}
+	return DriverSQLite, s
+}
+
+// Name returns a name to show for a database. Only sqlite databases are shown by their dsn,
+// the others contain credentials.
+func Name(driver string, dsn string) string {
+	if driver == DriverSQLite || driver == "" {
+		return dsn
+	}
+	return driver
+}
+
+// InitDB connects to a database and panics if it can't. The schema is managed with Migrate.
+func InitDB(driver string, dsn string, log *logrus.Logger) *gorm.DB {
+	db, err := OpenDB(driver, dsn, log)
 	if err != nil {
-		log.Panic("failed to connect to database.")
+		log.WithError(err).WithField("database", Name(driver, dsn)).Panic("failed to connect to database.")
 	}
-	db.AutoMigrate(&SystemConfig{}, &User{})
 
 	return db
 }
+
+// OpenDB connects to a database.
+func OpenDB(driver string, dsn string, log *logrus.Logger) (*gorm.DB, error) {
+	d, err := dialector(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return gorm.Open(d, &gorm.Config{
+		Logger: newLogger(log),
+	})
+}
+
+// slowQueryThreshold is how long a query may take before it's logged as slow.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger logs gorm's queries to our logger. The sql is never logged, gorm interpolates the
+// values into it and they include telegram ids.
+type gormLogger struct {
+	log *logrus.Logger
+}
+
+// newLogger makes gorm log slow queries and errors as warnings. Every query is logged at debug level.
+func newLogger(log *logrus.Logger) logger.Interface {
+	return gormLogger{log: log}
+}
+
+func (l gormLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	l.log.WithContext(ctx).Infof(msg, args...)
+}
+
+func (l gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	l.log.WithContext(ctx).Warnf(msg, args...)
+}
+
+func (l gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	l.log.WithContext(ctx).Errorf(msg, args...)
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	failed := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+	slow := elapsed > slowQueryThreshold
+	if !failed && !slow && !l.log.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+
+	// fc builds the sql as well, only the number of rows is used.
+	_, rows := fc()
+	entry := l.log.WithContext(ctx).WithFields(logrus.Fields{
+		"rows":    rows,
+		"elapsed": elapsed,
+	})
+
+	switch {
+	case failed:
+		entry.WithError(err).Warn("query failed.")
+	case slow:
+		entry.Warn("slow query.")
+	default:
+		entry.Debug("query.")
+	}
+}
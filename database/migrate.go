@@ -0,0 +1,174 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned change to the database schema. Up applies it and Down reverts it.
+// Migrations must only use their own copies of the models, so that they keep working when the models change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaVersion records an applied migration.
+type SchemaVersion struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// MigrationState is a migration together with when it was applied, if it was.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LatestVersion returns the schema version the models in this package expect.
+func LatestVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// ErrRevertAll is returned by MigrateDown when it would revert the first migration, which drops the users.
+var ErrRevertAll = errors.New("reverting the first migration deletes every user")
+
+// CurrentVersion returns the version of the last applied migration, 0 if there is none.
+// It doesn't change the database, a database without a schema_version table is at version 0.
+func CurrentVersion(db *gorm.DB) (int, error) {
+	var version int
+
+	if !db.Migrator().HasTable(&SchemaVersion{}) {
+		return 0, nil
+	}
+
+	err := db.Model(&SchemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// MigrationStatus returns every known migration and whether it has been applied.
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	var applied []SchemaVersion
+
+	if db.Migrator().HasTable(&SchemaVersion{}) {
+		if err := db.Find(&applied).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, v := range applied {
+		appliedAt[v.Version] = v.AppliedAt
+	}
+
+	var states []MigrationState
+	for _, m := range Migrations {
+		state := MigrationState{Migration: m}
+		if t, ok := appliedAt[m.Version]; ok {
+			state.AppliedAt = &t
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Migrate applies or reverts migrations until the schema is at the target version.
+// Every migration runs in its own transaction, it returns the version that was reached. On MySQL the schema
+// changes of a failed migration aren't rolled back, running it again picks up where it stopped.
+func Migrate(db *gorm.DB, target int) (int, error) {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	if target < 0 || target > LatestVersion() {
+		return current, fmt.Errorf("unknown schema version %d, the latest is %d", target, LatestVersion())
+	}
+	if current > LatestVersion() {
+		return current, fmt.Errorf("schema version %d is newer than this version of secretsquirrel knows (%d)", current, LatestVersion())
+	}
+	if target > current {
+		if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+			return current, err
+		}
+	}
+
+	for _, m := range Migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return current, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		current = m.Version
+	}
+
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		m := Migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaVersion{}, m.Version).Error
+		})
+		if err != nil {
+			return current, fmt.Errorf("reverting migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		current = 0
+		if i > 0 {
+			current = Migrations[i-1].Version
+		}
+	}
+
+	return current, nil
+}
+
+// MigrateUp applies every pending migration.
+func MigrateUp(db *gorm.DB) (int, error) {
+	return Migrate(db, LatestVersion())
+}
+
+// MigrateDown reverts the given number of migrations. It returns ErrRevertAll instead of reverting the
+// first migration, unless force is set.
+func MigrateDown(db *gorm.DB, steps int, force bool) (int, error) {
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if current == 0 {
+		return 0, errors.New("no migrations to revert")
+	}
+
+	target := 0
+	for i, m := range Migrations {
+		if m.Version == current && i-steps >= 0 {
+			target = Migrations[i-steps].Version
+		}
+	}
+
+	if target < Migrations[0].Version && !force {
+		return current, ErrRevertAll
+	}
+
+	return Migrate(db, target)
+}
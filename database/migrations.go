@@ -0,0 +1,237 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations lists every schema change in order. Append new migrations at the end and never change applied ones.
+//
+// MySQL commits schema changes immediately, so a migration that fails there is only partly rolled back.
+// Every step must therefore be safe to run again: AutoMigrate and DropTable are, columns are changed with
+// addColumn and dropColumn.
+//
+// The first migrations describe the schema that used to be created with AutoMigrate. They are written with
+// AutoMigrate as well, so databases that were created before migrations existed are picked up as they are.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "users and system config",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&systemConfigV1{}, &userV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userV1{}, &systemConfigV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "message cache",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&cachedMessageV2{}, &cachedUpvoteV2{}, &cachedMappingV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&cachedMappingV2{}, &cachedUpvoteV2{}, &cachedMessageV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "warning expiry",
+		Up: func(tx *gorm.DB) error {
+			return addColumn(tx, &userV3{}, "WarnExpiry")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumn(tx, &userV3{}, "WarnExpiry")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "sign limit",
+		Up: func(tx *gorm.DB) error {
+			return addColumn(tx, &userV4{}, "LastSigned")
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumn(tx, &userV4{}, "LastSigned")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "outbound queue",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&outboundJobV5{}, &deadLetterV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&deadLetterV5{}, &outboundJobV5{})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "moderation log",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&modActionV6{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&modActionV6{})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "reports",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&reportV7{}); err != nil {
+				return err
+			}
+			return addColumn(tx, &cachedMessageV7{}, "Reported")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := dropColumn(tx, &cachedMessageV7{}, "Reported"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&reportV7{})
+		},
+	},
+}
+
+// addColumn adds a column unless it exists already, because AutoMigrate created it before migrations existed
+// or a failed run of the migration added it.
+func addColumn(tx *gorm.DB, model interface{}, field string) error {
+	if tx.Migrator().HasColumn(model, field) {
+		return nil
+	}
+	return tx.Migrator().AddColumn(model, field)
+}
+
+// dropColumn drops a column unless a failed run of the migration dropped it already.
+func dropColumn(tx *gorm.DB, model interface{}, field string) error {
+	if !tx.Migrator().HasColumn(model, field) {
+		return nil
+	}
+	return tx.Migrator().DropColumn(model, field)
+}
+
+type systemConfigV1 struct {
+	Name  string `gorm:"primaryKey"`
+	Value string
+}
+
+func (systemConfigV1) TableName() string { return "system_configs" }
+
+type userV1 struct {
+	ID              int64 `gorm:"primaryKey"`
+	UserName        string
+	RealName        string
+	Rank            int
+	Joined          time.Time
+	Left            sql.NullTime
+	LastActive      time.Time
+	CooldownUntil   sql.NullTime
+	BlacklistReason string
+	Warnings        int
+	Karma           int
+	HideKarma       bool
+	DebugEnabled    bool
+	Tripcode        string
+	ToggleTripcode  bool
+}
+
+func (userV1) TableName() string { return "users" }
+
+type cachedMessageV2 struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	UserID   int64
+	Time     time.Time
+	Warned   bool
+	Signed   bool
+	Tripcode bool
+	Official string
+}
+
+func (cachedMessageV2) TableName() string { return "cached_messages" }
+
+type cachedUpvoteV2 struct {
+	MessageID int   `gorm:"primaryKey;autoIncrement:false"`
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+}
+
+func (cachedUpvoteV2) TableName() string { return "cached_upvotes" }
+
+type cachedMappingV2 struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID int   `gorm:"primaryKey;autoIncrement:false"`
+	MappedID  int
+}
+
+func (cachedMappingV2) TableName() string { return "cached_mappings" }
+
+type userV3 struct {
+	ID         int64 `gorm:"primaryKey"`
+	WarnExpiry sql.NullTime
+}
+
+func (userV3) TableName() string { return "users" }
+
+type userV4 struct {
+	ID         int64 `gorm:"primaryKey"`
+	LastSigned sql.NullTime
+}
+
+func (userV4) TableName() string { return "users" }
+
+type outboundJobV5 struct {
+	ID          uint  `gorm:"primaryKey"`
+	RecipientID int64 `gorm:"index:idx_outbound_jobs_recipient_id"`
+	Payload     string
+	Attempts    int
+	NextAttempt time.Time `gorm:"index:idx_outbound_jobs_next_attempt"`
+	LastError   string
+	CreatedAt   time.Time
+}
+
+func (outboundJobV5) TableName() string { return "outbound_jobs" }
+
+type deadLetterV5 struct {
+	ID          uint  `gorm:"primaryKey"`
+	RecipientID int64 `gorm:"index:idx_dead_letters_recipient_id"`
+	Payload     string
+	Attempts    int
+	LastError   string
+	CreatedAt   time.Time
+	FailedAt    time.Time
+}
+
+func (deadLetterV5) TableName() string { return "dead_letters" }
+
+type modActionV6 struct {
+	ID             uint   `gorm:"primaryKey"`
+	ActorID        int64  `gorm:"index:idx_mod_actions_actor_id"`
+	TargetID       int64  `gorm:"index:idx_mod_actions_target_id"`
+	Action         string `gorm:"index:idx_mod_actions_action"`
+	Reason         string
+	CacheMessageID sql.NullInt64
+	CreatedAt      time.Time `gorm:"index:idx_mod_actions_created_at"`
+}
+
+func (modActionV6) TableName() string { return "mod_actions" }
+
+type reportV7 struct {
+	ID             uint `gorm:"primaryKey"`
+	CacheMessageID int  `gorm:"index:idx_reports_cache_message_id"`
+	ReporterID     int64
+	TargetID       int64 `gorm:"index:idx_reports_target_id"`
+	Reason         string
+	CreatedAt      time.Time
+	ResolvedAt     sql.NullTime
+	ResolvedBy     int64
+	Resolution     string
+}
+
+func (reportV7) TableName() string { return "reports" }
+
+type cachedMessageV7 struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	Reported bool
+}
+
+func (cachedMessageV7) TableName() string { return "cached_messages" }
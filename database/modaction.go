@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ModActionType string
+
+const (
+	ActionWarn         ModActionType = "warn"
+	ActionDelete       ModActionType = "delete"
+	ActionRemove       ModActionType = "remove"
+	ActionBlacklist    ModActionType = "blacklist"
+	ActionUnblacklist  ModActionType = "unblacklist"
+	ActionUncooldown   ModActionType = "uncooldown"
+	ActionPromoteMod   ModActionType = "promote_mod"
+	ActionPromoteAdmin ModActionType = "promote_admin"
+	ActionDemote       ModActionType = "demote"
+)
+
+// ModActionTypes lists every action that is recorded in the moderation log.
+var ModActionTypes = []ModActionType{
+	ActionWarn, ActionDelete, ActionRemove, ActionBlacklist, ActionUnblacklist,
+	ActionUncooldown, ActionPromoteMod, ActionPromoteAdmin, ActionDemote,
+}
+
+// ConsoleActorID is the actor of actions taken through secretsqcli.
+const ConsoleActorID int64 = 0
+
+// ModAction is an entry in the moderation log. Entries are only ever appended.
+type ModAction struct {
+	ID             uint          `gorm:"primaryKey"`
+	ActorID        int64         `gorm:"index"`
+	TargetID       int64         `gorm:"index"`
+	Action         ModActionType `gorm:"index"`
+	Reason         string
+	CacheMessageID sql.NullInt64
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+// ParseModActionType returns the action with the given name.
+func ParseModActionType(s string) (ModActionType, bool) {
+	for _, a := range ModActionTypes {
+		if string(a) == s {
+			return a, true
+		}
+	}
+	return "", false
+}
+
+// NewModAction creates a moderation log entry. msid is the cache message id the action was taken on, or -1.
+func NewModAction(action ModActionType, actorID int64, targetID int64, reason string, msid int) *ModAction {
+	ma := ModAction{
+		ActorID:  actorID,
+		TargetID: targetID,
+		Action:   action,
+		Reason:   reason,
+	}
+	if msid != -1 {
+		ma.CacheMessageID = sql.NullInt64{Int64: int64(msid), Valid: true}
+	}
+	return &ma
+}
+
+func SaveModAction(db *gorm.DB, ma *ModAction) error {
+	return db.Create(ma).Error
+}
+
+func ModActionsByActor(actorID int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("actor_id = ?", actorID)
+	}
+}
+
+func ModActionsByTarget(targetID int64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("target_id = ?", targetID)
+	}
+}
+
+func ModActionsByAction(action ModActionType) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("action = ?", action)
+	}
+}
+
+func ModActionsSince(t time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("created_at >= ?", t)
+	}
+}
+
+// FindModActions returns up to limit moderation log entries, newest first.
+func FindModActions(db *gorm.DB, limit int, scopes ...func(*gorm.DB) *gorm.DB) ([]ModAction, error) {
+	var actions []ModAction
+
+	if err := db.Scopes(scopes...).Order("id DESC").Limit(limit).Find(&actions).Error; err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
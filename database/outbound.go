@@ -0,0 +1,86 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboundJob is a relayed message waiting to be delivered to a single recipient.
+type OutboundJob struct {
+	ID          uint  `gorm:"primaryKey"`
+	RecipientID int64 `gorm:"index"`
+	Payload     string
+	Attempts    int
+	NextAttempt time.Time `gorm:"index"`
+	LastError   string
+	CreatedAt   time.Time
+}
+
+// DeadLetter is an outbound job that permanently failed to be delivered.
+type DeadLetter struct {
+	ID          uint  `gorm:"primaryKey"`
+	RecipientID int64 `gorm:"index"`
+	Payload     string
+	Attempts    int
+	LastError   string
+	CreatedAt   time.Time
+	FailedAt    time.Time
+}
+
+func SaveOutboundJobs(db *gorm.DB, jobs []*OutboundJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	return db.CreateInBatches(jobs, 100).Error
+}
+
+// FindDueOutboundJobs returns up to limit jobs that are due to be (re)tried, oldest first.
+func FindDueOutboundJobs(db *gorm.DB, limit int) ([]OutboundJob, error) {
+	var jobs []OutboundJob
+
+	err := db.Where("next_attempt <= ?", time.Now()).Order("id").Limit(limit).Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// CountOutboundJobs returns the number of jobs that haven't been delivered or dead-lettered yet.
+func CountOutboundJobs(db *gorm.DB) (int64, error) {
+	var count int64
+
+	err := db.Model(&OutboundJob{}).Count(&count).Error
+	return count, err
+}
+
+func RescheduleOutboundJob(db *gorm.DB, job *OutboundJob) error {
+	return db.Model(job).Updates(map[string]interface{}{
+		"attempts":     job.Attempts,
+		"next_attempt": job.NextAttempt,
+		"last_error":   job.LastError,
+	}).Error
+}
+
+func DeleteOutboundJob(db *gorm.DB, id uint) error {
+	return db.Delete(&OutboundJob{}, id).Error
+}
+
+// DeadLetterOutboundJob moves a job to the dead letter table.
+func DeadLetterOutboundJob(db *gorm.DB, job *OutboundJob) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		dl := DeadLetter{
+			RecipientID: job.RecipientID,
+			Payload:     job.Payload,
+			Attempts:    job.Attempts,
+			LastError:   job.LastError,
+			CreatedAt:   job.CreatedAt,
+			FailedAt:    time.Now(),
+		}
+		if err := tx.Create(&dl).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&OutboundJob{}, job.ID).Error
+	})
+}
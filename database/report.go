@@ -0,0 +1,61 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Report is a message that a user flagged for review by the moderators.
+type Report struct {
+	ID             uint `gorm:"primaryKey"`
+	CacheMessageID int  `gorm:"index"`
+	ReporterID     int64
+	TargetID       int64 `gorm:"index"`
+	Reason         string
+	CreatedAt      time.Time
+	ResolvedAt     sql.NullTime
+	ResolvedBy     int64
+	Resolution     string
+}
+
+func (r *Report) IsResolved() bool {
+	return r.ResolvedAt.Valid
+}
+
+func SaveReport(db *gorm.DB, report *Report) error {
+	return db.Create(report).Error
+}
+
+func FindReport(db *gorm.DB, id uint) (*Report, error) {
+	var report Report
+
+	if err := db.First(&report, id).Error; err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// ResolveReport marks a report as handled. It reports false if someone else resolved it first.
+func ResolveReport(db *gorm.DB, report *Report, actorID int64, resolution string) (bool, error) {
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+
+	res := db.Model(&Report{}).Where("id = ? AND resolved_at IS NULL", report.ID).Updates(map[string]interface{}{
+		"resolved_at": now,
+		"resolved_by": actorID,
+		"resolution":  resolution,
+	})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return false, nil
+	}
+
+	report.ResolvedAt = now
+	report.ResolvedBy = actorID
+	report.Resolution = resolution
+	return true, nil
+}
@@ -0,0 +1,296 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// ranks used by secretlounge-ng.
+const (
+	secretLoungeRankBanned = -10
+	secretLoungeRankUser   = 0
+	secretLoungeRankMod    = 10
+	secretLoungeRankAdmin  = 100
+)
+
+// SecretLoungeImport is the content of a secretlounge-ng database, converted to secretsquirrel's models.
+type SecretLoungeImport struct {
+	Users []User
+	Motd  string
+	// Skipped lists the users that could not be converted and why.
+	Skipped []string
+	// Warnings lists users that were converted with some data dropped.
+	Warnings []string
+}
+
+// secretLoungeUser is a user as secretlounge-ng stores it. Times are either text (sqlite) or unix timestamps (json).
+type secretLoungeUser struct {
+	ID              int64       `json:"id"`
+	Username        *string     `json:"username"`
+	Realname        string      `json:"realname"`
+	Rank            int         `json:"rank"`
+	Joined          interface{} `json:"joined"`
+	Left            interface{} `json:"left"`
+	LastActive      interface{} `json:"lastActive"`
+	CooldownUntil   interface{} `json:"cooldownUntil"`
+	BlacklistReason *string     `json:"blacklistReason"`
+	Warnings        int         `json:"warnings"`
+	WarnExpiry      interface{} `json:"warnExpiry"`
+	Karma           int         `json:"karma"`
+	HideKarma       bool        `json:"hideKarma"`
+	DebugEnabled    bool        `json:"debugEnabled"`
+	Tripcode        *string     `json:"tripcode"`
+}
+
+type secretLoungeJSON struct {
+	SystemConfig map[string]interface{} `json:"systemConfig"`
+	Users        []secretLoungeUser     `json:"users"`
+}
+
+// ReadSecretLounge reads a secretlounge-ng database, either its sqlite database or its json storage file.
+func ReadSecretLounge(path string) (*SecretLoungeImport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return readSecretLoungeJSON(data)
+	}
+	return readSecretLoungeSQLite(path)
+}
+
+func readSecretLoungeJSON(data []byte) (*SecretLoungeImport, error) {
+	var db secretLoungeJSON
+
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("invalid secretlounge-ng json database: %w", err)
+	}
+
+	imp := &SecretLoungeImport{}
+	if motd, ok := db.SystemConfig["motd"].(string); ok {
+		imp.Motd = motd
+	}
+	for _, u := range db.Users {
+		imp.add(u)
+	}
+	return imp, nil
+}
+
+func readSecretLoungeSQLite(path string) (*SecretLoungeImport, error) {
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	if !db.Migrator().HasTable("users") {
+		return nil, fmt.Errorf("%s is not a secretlounge-ng database", path)
+	}
+
+	imp := &SecretLoungeImport{}
+
+	var motd sql.NullString
+	if db.Migrator().HasTable("system_config") {
+		err = db.Table("system_config").Select("value").Where("name = ?", "motd").Limit(1).Scan(&motd).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	imp.Motd = motd.String
+
+	// the times are selected as text, so the driver doesn't try to parse python's format itself.
+	rows, err := db.Raw("SELECT id, username, realname, `rank`, CAST(joined AS TEXT), CAST(`left` AS TEXT), " +
+		"CAST(lastActive AS TEXT), CAST(cooldownUntil AS TEXT), blacklistReason, warnings, CAST(warnExpiry AS TEXT), " +
+		"karma, hideKarma, debugEnabled, tripcode FROM users").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			u                                                   secretLoungeUser
+			joined, left, lastActive, cooldownUntil, warnExpiry sql.NullString
+		)
+
+		err := rows.Scan(&u.ID, &u.Username, &u.Realname, &u.Rank, &joined, &left, &lastActive, &cooldownUntil,
+			&u.BlacklistReason, &u.Warnings, &warnExpiry, &u.Karma, &u.HideKarma, &u.DebugEnabled, &u.Tripcode)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range []struct {
+			dst *interface{}
+			src sql.NullString
+		}{
+			{&u.Joined, joined}, {&u.Left, left}, {&u.LastActive, lastActive},
+			{&u.CooldownUntil, cooldownUntil}, {&u.WarnExpiry, warnExpiry},
+		} {
+			if t.src.Valid {
+				*t.dst = t.src.String
+			}
+		}
+
+		imp.add(u)
+	}
+
+	return imp, rows.Err()
+}
+
+// add converts a secretlounge-ng user.
+func (imp *SecretLoungeImport) add(u secretLoungeUser) {
+	user, warnings, err := convertSecretLoungeUser(u)
+	if err != nil {
+		imp.Skipped = append(imp.Skipped, fmt.Sprintf("%d: %s", u.ID, err))
+		return
+	}
+	for _, w := range warnings {
+		imp.Warnings = append(imp.Warnings, fmt.Sprintf("%d: %s", u.ID, w))
+	}
+	imp.Users = append(imp.Users, user)
+}
+
+func convertSecretLoungeUser(u secretLoungeUser) (User, []string, error) {
+	var (
+		warnings []string
+		err      error
+	)
+
+	if u.ID == 0 {
+		return User{}, nil, fmt.Errorf("missing id")
+	}
+
+	user := User{
+		ID:           u.ID,
+		RealName:     u.Realname,
+		Rank:         convertSecretLoungeRank(u.Rank),
+		Warnings:     u.Warnings,
+		Karma:        u.Karma,
+		HideKarma:    u.HideKarma,
+		DebugEnabled: u.DebugEnabled,
+	}
+	if u.Username != nil {
+		user.UserName = *u.Username
+	}
+	if u.BlacklistReason != nil {
+		user.BlacklistReason = *u.BlacklistReason
+	}
+
+	if u.Tripcode != nil && *u.Tripcode != "" {
+		if strings.Contains(*u.Tripcode, "#") {
+			user.Tripcode = *u.Tripcode
+		} else {
+			warnings = append(warnings, "dropped invalid tripcode")
+		}
+	}
+
+	joined, err := parseSecretLoungeTime(u.Joined)
+	if err != nil {
+		return User{}, nil, fmt.Errorf("joined: %w", err)
+	}
+	if !joined.Valid {
+		return User{}, nil, fmt.Errorf("missing join time")
+	}
+	user.Joined = joined.Time
+
+	lastActive, err := parseSecretLoungeTime(u.LastActive)
+	if err != nil {
+		return User{}, nil, fmt.Errorf("lastActive: %w", err)
+	}
+	user.LastActive = joined.Time
+	if lastActive.Valid {
+		user.LastActive = lastActive.Time
+	}
+
+	if user.Left, err = parseSecretLoungeTime(u.Left); err != nil {
+		return User{}, nil, fmt.Errorf("left: %w", err)
+	}
+	if user.CooldownUntil, err = parseSecretLoungeTime(u.CooldownUntil); err != nil {
+		return User{}, nil, fmt.Errorf("cooldownUntil: %w", err)
+	}
+	if user.WarnExpiry, err = parseSecretLoungeTime(u.WarnExpiry); err != nil {
+		return User{}, nil, fmt.Errorf("warnExpiry: %w", err)
+	}
+
+	// secretsquirrel expects banned users to have left.
+	if user.Rank == RankBanned && !user.Left.Valid {
+		user.Left = sql.NullTime{Time: user.LastActive, Valid: true}
+	}
+
+	return user, warnings, nil
+}
+
+func convertSecretLoungeRank(rank int) UserRank {
+	switch {
+	case rank >= secretLoungeRankAdmin:
+		return RankAdmin
+	case rank >= secretLoungeRankMod:
+		return RankMod
+	case rank >= secretLoungeRankUser:
+		return RankUser
+	default:
+		return RankBanned
+	}
+}
+
+var secretLoungeTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+// parseSecretLoungeTime parses a time stored by secretlounge-ng. It saves naive utc times.
+func parseSecretLoungeTime(v interface{}) (sql.NullTime, error) {
+	switch t := v.(type) {
+	case nil:
+		return sql.NullTime{}, nil
+	case float64:
+		return sql.NullTime{Time: time.Unix(int64(t), 0), Valid: true}, nil
+	case string:
+		if t == "" {
+			return sql.NullTime{}, nil
+		}
+		if n, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return sql.NullTime{Time: time.Unix(n, 0), Valid: true}, nil
+		}
+		for _, layout := range secretLoungeTimeLayouts {
+			if parsed, err := time.ParseInLocation(layout, t, time.UTC); err == nil {
+				return sql.NullTime{Time: parsed.Local(), Valid: true}, nil
+			}
+		}
+		return sql.NullTime{}, fmt.Errorf("invalid time %q", t)
+	default:
+		return sql.NullTime{}, fmt.Errorf("invalid time %v", v)
+	}
+}
+
+// SaveSecretLoungeImport writes imported users and the motd in one transaction. Existing users are overwritten.
+func SaveSecretLoungeImport(db *gorm.DB, imp *SecretLoungeImport) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(imp.Users) > 0 {
+			err := tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(imp.Users, 100).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if imp.Motd != "" {
+			return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&SystemConfig{Name: "motd", Value: imp.Motd}).Error
+		}
+		return nil
+	})
+}
@@ -2,8 +2,10 @@ package database
 
 import (
 	"errors"
+	"strconv"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SystemConfig struct {
@@ -44,3 +46,25 @@ func SetMotd(db *gorm.DB, text string) error {
 	db.Save(&motd)
 	return nil
 }
+
+// GetCacheCounter returns the id the message cache continues counting from, 0 if none was saved yet.
+func GetCacheCounter(db *gorm.DB) (int, error) {
+	var counter SystemConfig
+
+	err := db.Where("name = ?", "cache_counter").First(&counter).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(counter.Value)
+}
+
+// SetCacheCounter saves the id the message cache continues counting from, so ids of expired messages
+// aren't handed out again after a restart.
+func SetCacheCounter(db *gorm.DB, n int) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&SystemConfig{
+		Name:  "cache_counter",
+		Value: strconv.Itoa(n),
+	}).Error
+}
@@ -12,6 +12,7 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRank int
@@ -49,11 +50,13 @@ type User struct {
 	CooldownUntil   sql.NullTime
 	BlacklistReason string
 	Warnings        int
+	WarnExpiry      sql.NullTime
 	Karma           int
 	HideKarma       bool
 	DebugEnabled    bool
 	Tripcode        string
 	ToggleTripcode  bool
+	LastSigned      sql.NullTime
 }
 
 func (u *User) GetFormattedUsername() string {
@@ -110,8 +113,26 @@ func (u *User) GetObfuscatedKarma() int {
 	return u.Karma + rand.Intn(offset+1) - offset
 }
 
+// AreJoined selects the users currently in the chat. "rank" and "left" are reserved words in some databases,
+// so they are only used through clauses that let the dialect quote them.
 func AreJoined(db *gorm.DB) *gorm.DB {
-	return db.Not("rank = ?", RankBanned).Where("left IS NULL")
+	return db.Where(clause.Neq{Column: "rank", Value: RankBanned}).Where(clause.Eq{Column: "left", Value: nil})
+}
+
+func ByRank(rank UserRank) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: "rank", Value: rank})
+	}
+}
+
+// ByLastActive orders users by activity, most recently active first.
+func ByLastActive(db *gorm.DB) *gorm.DB {
+	return db.Order("last_active DESC")
+}
+
+// WarningExpired selects users with a warning that is due to expire.
+func WarningExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("warnings > 0 AND warn_expiry IS NOT NULL AND warn_expiry < ?", time.Now())
 }
 
 func ByUsernameOrID(u string) func(db *gorm.DB) *gorm.DB {
@@ -119,20 +140,17 @@ func ByUsernameOrID(u string) func(db *gorm.DB) *gorm.DB {
 	userID, _ := strconv.ParseInt(u, 10, 64)
 
 	if userID != 0 {
-		return func(db *gorm.DB) *gorm.DB {
-			return db.Where("id = ?", userID)
-		}
-
+		return ByID(userID)
 	} else {
-		return func(db *gorm.DB) *gorm.DB {
-			return db.Where("user_name = ?", u)
-		}
+		return ByUsername(u)
 	}
 }
 
+// ByUsername matches usernames case-insensitively, which telegram usernames are.
+// Comparing lowercased names works the same across databases regardless of their collation.
 func ByUsername(username string) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		return db.Where("user_name = ?", strings.ToLower(username))
+		return db.Where("LOWER(user_name) = ?", strings.ToLower(username))
 	}
 }
 
@@ -174,11 +192,13 @@ func NewUser(db *gorm.DB, tgUser *tgbotapi.User) *User {
 		CooldownUntil:   sql.NullTime{},
 		BlacklistReason: "",
 		Warnings:        0,
+		WarnExpiry:      sql.NullTime{},
 		Karma:           0,
 		HideKarma:       false,
 		DebugEnabled:    false,
 		Tripcode:        "",
 		ToggleTripcode:  false,
+		LastSigned:      sql.NullTime{},
 	}
 	return &user
 }
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// testDatabases returns the databases the tests run against: always a temporary sqlite database, and postgres
+// or mysql if a dsn is given in SECRETSQUIRREL_TEST_POSTGRES_DSN or SECRETSQUIRREL_TEST_MYSQL_DSN.
+func testDatabases(t *testing.T) map[string]*gorm.DB {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	dsns := map[string]string{
+		DriverSQLite:   filepath.Join(t.TempDir(), "test.db"),
+		DriverPostgres: os.Getenv("SECRETSQUIRREL_TEST_POSTGRES_DSN"),
+		DriverMySQL:    os.Getenv("SECRETSQUIRREL_TEST_MYSQL_DSN"),
+	}
+
+	dbs := map[string]*gorm.DB{}
+	for driver, dsn := range dsns {
+		if dsn == "" {
+			continue
+		}
+
+		db, err := OpenDB(driver, dsn, log)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if _, err := MigrateUp(db); err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		t.Cleanup(func() {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+		})
+		dbs[driver] = db
+	}
+	return dbs
+}
+
+// seedUsers replaces the users with a fixed set inside a transaction that is rolled back after the test,
+// so databases given by dsn are left as they were.
+func seedUsers(t *testing.T, db *gorm.DB) *gorm.DB {
+	t.Helper()
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+
+	left := sql.NullTime{Time: time.Now(), Valid: true}
+	users := []User{
+		{ID: 1, UserName: "Alice", Rank: RankAdmin},
+		{ID: 2, UserName: "bob", Rank: RankMod},
+		{ID: 3, UserName: "Carol", Rank: RankUser, Left: left},
+		{ID: 4, UserName: "dave", Rank: RankBanned},
+		{ID: 5, Rank: RankUser},
+	}
+
+	if err := tx.Where("1 = 1").Delete(&User{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Create(&users).Error; err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func userIDs(users []User) []int64 {
+	ids := make([]int64, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func equalIDs(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUserScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []func(*gorm.DB) *gorm.DB
+		want   []int64
+	}{
+		{"joined", []func(*gorm.DB) *gorm.DB{AreJoined}, []int64{1, 2, 5}},
+		{"admins", []func(*gorm.DB) *gorm.DB{ByRank(RankAdmin)}, []int64{1}},
+		{"mods", []func(*gorm.DB) *gorm.DB{ByRank(RankMod)}, []int64{2}},
+		{"users", []func(*gorm.DB) *gorm.DB{ByRank(RankUser)}, []int64{3, 5}},
+		{"banned", []func(*gorm.DB) *gorm.DB{ByRank(RankBanned)}, []int64{4}},
+		{"joined users", []func(*gorm.DB) *gorm.DB{AreJoined, ByRank(RankUser)}, []int64{5}},
+		{"username", []func(*gorm.DB) *gorm.DB{ByUsername("bob")}, []int64{2}},
+		{"username in other case", []func(*gorm.DB) *gorm.DB{ByUsername("ALICE")}, []int64{1}},
+		{"username of user who left", []func(*gorm.DB) *gorm.DB{ByUsername("carol")}, []int64{3}},
+		{"unknown username", []func(*gorm.DB) *gorm.DB{ByUsername("eve")}, []int64{}},
+		{"id as username", []func(*gorm.DB) *gorm.DB{ByUsernameOrID("4")}, []int64{4}},
+	}
+
+	for driver, db := range testDatabases(t) {
+		t.Run(driver, func(t *testing.T) {
+			tx := seedUsers(t, db)
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					users, err := FindUsers(tx, tt.scopes...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := userIDs(users); !equalIDs(got, tt.want) {
+						t.Errorf("got users %v, want %v", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"secretsquirrel/config"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hashKey keys the hashes of user ids, so they can't be reversed by hashing every possible id.
+var hashKey []byte
+
+// New creates the logger described by the config.
+func New(cfg *config.Config) (*logrus.Logger, error) {
+	log := logrus.New()
+
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(level)
+
+	switch strings.ToLower(cfg.Log.Format) {
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+
+	if cfg.Log.File != "" {
+		f, err := os.OpenFile(cfg.Log.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, err
+		}
+		log.SetOutput(f)
+	}
+
+	hashKey = []byte(cfg.Log.HashKey)
+	if len(hashKey) == 0 {
+		hashKey = []byte(cfg.Bot.Token)
+	}
+
+	return log, nil
+}
+
+// UserID returns the form of a user id that is safe to log. The same id always hashes to the same value,
+// so a user's actions can be followed through the logs without revealing who they are.
+func UserID(id int64) string {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(strconv.FormatInt(id, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
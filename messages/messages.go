@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"secretsquirrel/database"
 	"text/template"
+	"time"
 )
 
 const (
@@ -18,6 +19,14 @@ const (
 	KarmaThankMessage        = "You just gave this user some sweet karma, awesome!"
 	KarmaNotificationMessage = "You've just been given sweet karma! (check /info to see your karma or /toggleKarma to turn these notifications off)"
 	VersionMessage           = "Secretsquirrel version %f - https://github.com/dazzleey/secretsquirrel"
+	CooldownRemovedMessage   = "Your cooldown has been lifted."
+	UserUncooldownedMessage  = "Cooldown removed from %s."
+	NoModActionsMessage      = "No moderation actions found."
+	ReportSentMessage        = "Your report has been sent to the moderators."
+	ReportCardMessage        = "<b>Report</b> #%d\n<b>User</b>: <code>%s</code>\n<b>Reason</b>: %s"
+	ReportResolvedMessage    = "\n\n<i>Resolved: %s</i>"
+	ReportHandledMessage     = "Done."
+	ReportDeletedOnlyMessage = "A warning has already been issued for this message, it was only deleted."
 
 	CommandDisabledError   = "This command has been disabled."
 	NoReplyError           = "You need to reply to a message to use this command."
@@ -35,6 +44,12 @@ const (
 	InvalidTripFormatError = "Given tripcode is not valid, the format is <code>name#pass</code>"
 	NoTripcodeError        = "You don't have a tripcode set."
 	MediaLimitError        = "You can't send media or forward messages at this time, try again later."
+	NoReportReasonError    = "You need to give a reason: /report &lt;reason&gt;"
+	AlreadyReportedError   = "This message has already been reported."
+	ReportOwnMessageError  = "You can't report your own message."
+	ReportResolvedError    = "This report has already been handled."
+	NotAllowedError        = "You are not allowed to do this."
+	CallbackExpiredError   = "This button has expired."
 
 	ModeratorHelp = `<i>Moderators can use the following commands</i>:
 	/modhelp - show this text
@@ -50,7 +65,8 @@ const (
 	/adminhelp - show this text
 	/adminsay &lt;message&gt; - send an official moderator message
 	/setmotd &lt;message&gt; - set the welcome message (HTML formatted)
-	/uncooldown &lt;id | username&gt - remove a cooldown from a user
+	/uncooldown &lt;id | username&gt; [unwarn] - remove a cooldown from a user (and one of their warnings)
+	/modlog [id | username] [action] - show recent moderation actions, optionally only those taken on a user or of one kind
 	/mod &lt;username&gt; - promote a user to moderator
 	/admin &lt;username&gt; - promote a user to admin
 	
@@ -66,6 +82,9 @@ const (
 
 	modUserInfoMessage = "<b>ID</b>: {{ .GetObfuscatedID }}\n<b>Karma</b>: {{ .GetObfuscatedKarma }}\n" +
 		"<b>Cooldown</b>:{{ if .IsInCooldown }} yes. {{ else }} no. {{ end }}"
+
+	modLogMessage = "<b>Moderation log</b>:{{ range . }}\n<code>{{ .Time.Format \"2006-01-02 15:04\" }}</code> <b>{{ .Action }}</b> " +
+		"by <code>{{ .Actor }}</code> on <code>{{ .Target }}</code>{{ if .Reason }}: {{ html .Reason }}{{ end }}{{ end }}"
 )
 
 type tripcodeTemplateConfig struct {
@@ -74,11 +93,21 @@ type tripcodeTemplateConfig struct {
 	TripPass string
 }
 
+// ModLogEntry is a moderation action as shown by /modlog. Actor and Target are the users' obfuscated ids.
+type ModLogEntry struct {
+	Time   time.Time
+	Action database.ModActionType
+	Actor  string
+	Target string
+	Reason string
+}
+
 var (
 	userInfoTemplate    *template.Template
 	modUserInfoTemplate *template.Template
 	newTripcodeTemplate *template.Template
 	tripcodeTemplate    *template.Template
+	modLogTemplate      *template.Template
 )
 
 func init() {
@@ -103,6 +132,11 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+
+	modLogTemplate, err = template.New("modLog").Parse(modLogMessage)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func UserInfo(user *database.User) (string, error) {
@@ -154,3 +188,14 @@ func TripcodeMessage(hasTrip bool, tripcode []string) (string, error) {
 
 	return tBuffer.String(), nil
 }
+
+func ModLog(entries []ModLogEntry) (string, error) {
+	var tBuffer bytes.Buffer
+
+	err := modLogTemplate.Execute(&tBuffer, entries)
+	if err != nil {
+		return "", err
+	}
+
+	return tBuffer.String(), nil
+}
@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"reflect"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Fake is an in-process API that records everything the bot sends instead of talking to telegram.
+// Updates can be injected with Push.
+type Fake struct {
+	mu      sync.Mutex
+	stop    sync.Once
+	nextID  int
+	sent    []tgbotapi.Chattable
+	ids     map[int64][]int
+	errors  map[int64]error
+	updates chan tgbotapi.Update
+
+	Self tgbotapi.User
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		mu:      sync.Mutex{},
+		stop:    sync.Once{},
+		nextID:  1,
+		sent:    []tgbotapi.Chattable{},
+		ids:     make(map[int64][]int),
+		errors:  make(map[int64]error),
+		updates: make(chan tgbotapi.Update, 100),
+		Self:    tgbotapi.User{ID: 1, IsBot: true, UserName: "fakebot"},
+	}
+}
+
+func (f *Fake) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	chatID := ChatID(c)
+	if err, ok := f.errors[chatID]; ok {
+		return tgbotapi.Message{}, err
+	}
+
+	f.sent = append(f.sent, c)
+	return f.newMessage(chatID), nil
+}
+
+func (f *Fake) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.errors[ChatID(c)]; ok {
+		return nil, err
+	}
+
+	f.sent = append(f.sent, c)
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+// MakeRequest accepts any raw request. Raw requests aren't recorded.
+func (f *Fake) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+// UploadFiles accepts any raw upload. Raw requests aren't recorded.
+func (f *Fake) UploadFiles(endpoint string, params tgbotapi.Params, files []tgbotapi.RequestFile) (*tgbotapi.APIResponse, error) {
+	return &tgbotapi.APIResponse{Ok: true, Result: []byte("true")}, nil
+}
+
+func (f *Fake) SendMediaGroup(config tgbotapi.MediaGroupConfig) ([]tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err, ok := f.errors[config.ChatID]; ok {
+		return nil, err
+	}
+
+	f.sent = append(f.sent, config)
+
+	messages := make([]tgbotapi.Message, len(config.Media))
+	for i := range config.Media {
+		messages[i] = f.newMessage(config.ChatID)
+	}
+	return messages, nil
+}
+
+func (f *Fake) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return f.updates
+}
+
+func (f *Fake) StopReceivingUpdates() {
+	f.stop.Do(func() { close(f.updates) })
+}
+
+func (f *Fake) GetMe() (tgbotapi.User, error) {
+	return f.Self, nil
+}
+
+// Push injects an update as if it was received from telegram.
+func (f *Fake) Push(u tgbotapi.Update) {
+	f.updates <- u
+}
+
+// FailFor makes every request to the given chat fail with err. A nil err clears the failure.
+func (f *Fake) FailFor(chatID int64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, chatID)
+		return
+	}
+	f.errors[chatID] = err
+}
+
+// Sent returns everything sent so far, in order.
+func (f *Fake) Sent() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sent := make([]tgbotapi.Chattable, len(f.sent))
+	copy(sent, f.sent)
+	return sent
+}
+
+// SentTo returns everything sent to the given chat, in order.
+func (f *Fake) SentTo(chatID int64) []tgbotapi.Chattable {
+	var sent []tgbotapi.Chattable
+
+	for _, c := range f.Sent() {
+		if ChatID(c) == chatID {
+			sent = append(sent, c)
+		}
+	}
+	return sent
+}
+
+// MessageIDs returns the ids of the messages created in the given chat, in order.
+func (f *Fake) MessageIDs(chatID int64) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]int, len(f.ids[chatID]))
+	copy(ids, f.ids[chatID])
+	return ids
+}
+
+// Reset forgets everything sent so far.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sent = []tgbotapi.Chattable{}
+	f.ids = make(map[int64][]int)
+}
+
+func (f *Fake) newMessage(chatID int64) tgbotapi.Message {
+	msg := tgbotapi.Message{
+		MessageID: f.nextID,
+		From:      &f.Self,
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+	}
+	f.ids[chatID] = append(f.ids[chatID], msg.MessageID)
+	f.nextID++
+	return msg
+}
+
+// ChatID returns the chat a Chattable is addressed to, or 0 if it doesn't have one.
+func ChatID(c tgbotapi.Chattable) int64 {
+	return findChatID(reflect.ValueOf(c))
+}
+
+// findChatID looks for a ChatID field in a config, including the ones of embedded BaseChat, BaseFile and BaseEdit.
+func findChatID(v reflect.Value) int64 {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	if f := v.FieldByName("ChatID"); f.IsValid() && f.Kind() == reflect.Int64 {
+		return f.Int()
+	}
+
+	return 0
+}